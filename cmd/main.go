@@ -2,13 +2,20 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"syncal/internal/config"
 	"syncal/internal/google"
-	"syncal/internal/icloud"
+	"syncal/internal/outlook"
+	"syncal/internal/server"
 	"syncal/internal/syncer"
+	"text/tabwriter"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -26,6 +33,11 @@ func main() {
 		Commands: []*cli.Command{
 			authCommand(),
 			syncCommand(),
+			diffCommand(),
+			statusCommand(),
+			conflictsCommand(),
+			purgeCommand(),
+			serveCommand(),
 		},
 	}
 
@@ -38,35 +50,57 @@ func main() {
 func authCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "auth",
-		Usage: "Authenticate with a Google account to get an API token.",
+		Usage: "Authenticate with a Google or Outlook account to get an API token.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "provider", Value: config.TypeGoogle, Usage: "The account type: 'google' or 'outlook'."},
+			&cli.BoolFlag{Name: "write", Usage: "Also request permission to create and modify events. Accounts that pipelines write to get it automatically."},
+		},
 		Action: func(c *cli.Context) error {
 			logger := setupLogger("info")
+			provider := c.String("provider")
+			if provider != config.TypeGoogle && provider != config.TypeOutlook {
+				return fmt.Errorf("unknown provider '%s', expected 'google' or 'outlook'", provider)
+			}
+
+			reader := bufio.NewReader(os.Stdin)
+			fmt.Print("Enter a name for this account (e.g., 'personal', 'work'): ")
+			accountName, _ := reader.ReadString('\n')
+			accountName = strings.TrimSpace(accountName)
+
+			// Write access is only requested for accounts that pipelines write to.
+			write := c.Bool("write")
+			if cfg, err := config.Load(); err == nil && slices.Contains(cfg.WriteAccounts(provider), accountName) {
+				write = true
+			}
+			if write {
+				logger.Info("Requesting permission to create and modify events.", "account", accountName)
+			}
+
+			if provider == config.TypeOutlook {
+				return authOutlook(c, logger, accountName, write)
+			}
+
 			logger.Info("Starting Google authentication flow.")
+			tokenFile := "token-" + accountName + ".json"
 
-			config, err := google.GetOAuthConfigForAuthFlow(os.Getenv("GOOGLE_CLIENT_ID"), os.Getenv("GOOGLE_CLIENT_SECRET"))
+			oauthConfig, err := google.GetOAuthConfigForAuthFlow(os.Getenv("GOOGLE_CLIENT_ID"), os.Getenv("GOOGLE_CLIENT_SECRET"), write)
 			if err != nil {
 				return fmt.Errorf("failed to get google oauth config: %w", err)
 			}
 
-			authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+			authURL := oauthConfig.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 			fmt.Printf("Go to the following link in your browser then type the "+
 				"authorization code: \n%v\n", authURL)
 
 			fmt.Print("Enter Authorization Code: ")
-			reader := bufio.NewReader(os.Stdin)
 			authCode, _ := reader.ReadString('\n')
 			authCode = strings.TrimSpace(authCode)
 
-			token, err := google.TokenFromWeb(config, authCode)
+			token, err := google.TokenFromWeb(oauthConfig, authCode)
 			if err != nil {
 				return fmt.Errorf("unable to retrieve token from web: %w", err)
 			}
 
-			fmt.Print("Enter a name for this account (e.g., 'personal', 'work'): ")
-			accountName, _ := reader.ReadString('\n')
-			accountName = strings.TrimSpace(accountName)
-			tokenFile := "token-" + accountName + ".json"
-
 			if err := google.SaveToken(tokenFile, token); err != nil {
 				return fmt.Errorf("failed to save token: %w", err)
 			}
@@ -77,6 +111,34 @@ func authCommand() *cli.Command {
 	}
 }
 
+// authOutlook runs the device code flow for an Outlook account and saves its token.
+func authOutlook(c *cli.Context, logger *slog.Logger, accountName string, write bool) error {
+	clientID := os.Getenv("MS_CLIENT_ID")
+	if clientID == "" {
+		return fmt.Errorf("MS_CLIENT_ID environment variable not set")
+	}
+	logger.Info("Starting Outlook authentication flow.")
+
+	oauthConfig := outlook.OAuthConfig(clientID, os.Getenv("MS_TENANT_ID"), write)
+	deviceAuth, err := oauthConfig.DeviceAuth(c.Context)
+	if err != nil {
+		return fmt.Errorf("failed to start device authorization: %w", err)
+	}
+	fmt.Printf("Go to %s in your browser and enter the code %s\n", deviceAuth.VerificationURI, deviceAuth.UserCode)
+
+	token, err := oauthConfig.DeviceAccessToken(c.Context, deviceAuth)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve token: %w", err)
+	}
+
+	tokenFile := outlook.TokenFile(accountName)
+	if err := outlook.SaveToken(tokenFile, token); err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+	logger.Info("Successfully authenticated and saved token.", "file", tokenFile)
+	return nil
+}
+
 func syncCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "sync",
@@ -85,82 +147,402 @@ func syncCommand() *cli.Command {
 			&cli.BoolFlag{Name: "once", Usage: "Run the sync cycle once and exit."},
 			&cli.BoolFlag{Name: "dry-run", Usage: "Log what would be synced without making changes."},
 			&cli.IntFlag{Name: "watch", Value: 300, Usage: "Run sync every N seconds. Overrides --once."},
+			&cli.StringFlag{Name: "pipeline", Usage: "Only sync this pipeline."},
 		},
 		Action: func(c *cli.Context) error {
-			logLevel := os.Getenv("LOG_LEVEL")
-			if logLevel == "" {
-				logLevel = "info"
-			}
-			logger := setupLogger(logLevel)
+			logger := loggerFromEnv()
 
 			if c.Bool("dry-run") {
 				logger.Info("Performing a dry run. No changes will be made.")
 			}
 
-			gClientIDs := os.Getenv("GOOGLE_CALENDAR_IDS")
-			if gClientIDs == "" {
-				return fmt.Errorf("GOOGLE_CALENDAR_IDS environment variable not set")
+			pipelines, err := newPipelines(c, logger, c.String("pipeline"), c.Bool("dry-run"))
+			if err != nil {
+				return err
+			}
+
+			// --watch flag takes precedence
+			if c.IsSet("watch") {
+				interval := time.Duration(c.Int("watch")) * time.Second
+				logger.Info("Starting watcher.", "interval", interval)
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for ; true; <-ticker.C {
+					for _, p := range pipelines {
+						if err := p.Sync(c.Context); err != nil {
+							logger.Error("Sync cycle failed", "error", err)
+						}
+					}
+				}
+			} else { // --once is the default behavior if --watch is not set
+				logger.Info("Running a single sync cycle.")
+				var errs []error
+				for _, p := range pipelines {
+					if err := p.Sync(c.Context); err != nil {
+						logger.Error("Sync cycle failed", "error", err)
+						errs = append(errs, err)
+					}
+				}
+				if err := errors.Join(errs...); err != nil {
+					return fmt.Errorf("single sync cycle failed: %w", err)
+				}
 			}
 
-			// Load all Google clients for all authenticated accounts
-			accounts, err := google.GetTokenAccounts()
+			return nil
+		},
+	}
+}
+
+func purgeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "purge",
+		Usage: "Delete all syncal-managed events from the targets of the pipelines.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "pipeline", Usage: "Only purge events written by this pipeline, or by one target of it ('<pipeline>/<target>')."},
+			&cli.BoolFlag{Name: "dry-run", Usage: "List the events that would be deleted without deleting them."},
+			&cli.BoolFlag{Name: "yes", Aliases: []string{"y"}, Usage: "Skip the confirmation prompt."},
+		},
+		Action: func(c *cli.Context) error {
+			logger := loggerFromEnv()
+
+			syncers, err := newPurgeSyncers(c, logger, c.String("pipeline"), c.Bool("dry-run"))
 			if err != nil {
-				return fmt.Errorf("could not find any google accounts, did you run auth command? %w", err)
+				return err
+			}
+
+			// Every target is purged through its own writer, so events are deleted from the calendar they were written to.
+			candidates := make([][]syncer.PurgeItem, len(syncers))
+			var total int
+			var pipelines []string
+			for i, s := range syncers {
+				items, err := s.PurgeCandidates(c.Context)
+				if err != nil {
+					return fmt.Errorf("failed to find events to purge: %w", err)
+				}
+				candidates[i] = items
+				total += len(items)
+				if len(items) > 0 {
+					pipelines = append(pipelines, items[0].Pipeline)
+				}
+			}
+			if total == 0 {
+				logger.Info("No syncal-managed events found, nothing to purge.")
+				return nil
 			}
-			if len(accounts) == 0 {
-				return fmt.Errorf("no google accounts found. Run the 'auth' command first")
+
+			if !c.Bool("dry-run") && !c.Bool("yes") {
+				fmt.Printf("About to delete %d events written by %s. Continue? [y/N]: ", total, strings.Join(pipelines, ", "))
+				reader := bufio.NewReader(os.Stdin)
+				answer, _ := reader.ReadString('\n')
+				answer = strings.ToLower(strings.TrimSpace(answer))
+				if answer != "y" && answer != "yes" {
+					logger.Info("Purge aborted.")
+					return nil
+				}
 			}
 
-			var gClients []*google.CalendarClient
-			for _, acc := range accounts {
-				gClient, err := google.NewClient(c.Context, logger, os.Getenv("GOOGLE_CLIENT_ID"), os.Getenv("GOOGLE_CLIENT_SECRET"), acc)
+			var deleted int
+			var errs []error
+			for i, s := range syncers {
+				if len(candidates[i]) == 0 {
+					continue
+				}
+				n, err := s.Purge(c.Context, candidates[i])
+				deleted += n
 				if err != nil {
-					return fmt.Errorf("failed to create google client for account %s: %w", acc, err)
+					errs = append(errs, fmt.Errorf("pipeline '%s': %w", candidates[i][0].Pipeline, err))
 				}
-				gClients = append(gClients, gClient)
 			}
-			logger.Info("Initialized Google clients for all accounts.", "count", len(gClients))
+			if err := errors.Join(errs...); err != nil {
+				return fmt.Errorf("purge failed after deleting %d events: %w", deleted, err)
+			}
+			logger.Info("Purge finished.", "deleted", deleted, "candidates", total)
+			return nil
+		},
+	}
+}
 
-			iClient, err := icloud.NewClient(logger, os.Getenv("ICLOUD_USERNAME"), os.Getenv("ICLOUD_APP_SPECIFIC_PASSWORD"), os.Getenv("ICLOUD_CALENDAR_NAME"))
+func diffCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "diff",
+		Usage: "Show the events a sync would create, update or delete, without changing anything.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "format", Value: "table", Usage: "Output format: 'table' or 'json'."},
+			&cli.BoolFlag{Name: "all", Usage: "Also list events that are unchanged."},
+			&cli.StringFlag{Name: "pipeline", Usage: "Only show the changes of this pipeline."},
+		},
+		Action: func(c *cli.Context) error {
+			logger := loggerFromEnv()
+
+			format := c.String("format")
+			if format != "table" && format != "json" {
+				return fmt.Errorf("unknown format '%s', expected 'table' or 'json'", format)
+			}
+
+			pipelines, err := newPipelines(c, logger, c.String("pipeline"), true)
 			if err != nil {
-				return fmt.Errorf("failed to create icloud client: %w", err)
+				return err
+			}
+
+			for _, p := range pipelines {
+				plans, err := p.Plan(c.Context)
+				if err != nil {
+					return fmt.Errorf("failed to compute sync plan: %w", err)
+				}
+
+				for _, plan := range plans {
+					if format == "json" {
+						enc := json.NewEncoder(os.Stdout)
+						enc.SetIndent("", "  ")
+						if err := enc.Encode(plan); err != nil {
+							return err
+						}
+						continue
+					}
+					printPlan(plan, c.Bool("all"))
+				}
 			}
+			return nil
+		},
+	}
+}
 
-			tzStr := os.Getenv("PRIMARY_TIMEZONE")
-			if tzStr == "" {
-				tzStr = "UTC"
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Serve the events of the pipelines as ICS subscriptions over HTTP.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "addr", Value: ":8080", Usage: "Address to listen on."},
+			&cli.StringFlag{Name: "token", EnvVars: []string{"SYNCAL_SERVE_TOKEN"}, Usage: "Token clients must send in the 'token' query parameter or as a bearer token."},
+			&cli.DurationFlag{Name: "refresh", Value: 5 * time.Minute, Usage: "Fetch the events again at most this often."},
+			&cli.StringFlag{Name: "pipeline", Usage: "Only serve this pipeline."},
+		},
+		Action: func(c *cli.Context) error {
+			logger := loggerFromEnv()
+
+			token := c.String("token")
+			if token == "" {
+				return fmt.Errorf("a token is required, set --token or SYNCAL_SERVE_TOKEN")
 			}
-			loc, err := time.LoadLocation(tzStr)
+
+			feeds, err := newFeeds(c, logger, c.String("pipeline"))
 			if err != nil {
-				return fmt.Errorf("invalid timezone '%s': %w", tzStr, err)
+				return err
+			}
+
+			srv := &http.Server{
+				Addr:              c.String("addr"),
+				Handler:           server.New(logger, token, c.Duration("refresh"), feeds),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			for _, feed := range feeds {
+				logger.Info("Serving calendar.", "pipeline", feed.Pipeline(), "path", "/"+feed.Pipeline()+".ics")
+			}
+			logger.Info("Listening for calendar subscriptions.", "addr", srv.Addr)
+			return srv.ListenAndServe()
+		},
+	}
+}
+
+func statusCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Usage: "Summarize the most recent sync runs.",
+		Flags: []cli.Flag{
+			&cli.IntFlag{Name: "runs", Value: 10, Usage: "Number of recent runs to list."},
+			&cli.StringFlag{Name: "format", Value: "table", Usage: "Output format: 'table' or 'json'."},
+		},
+		Action: func(c *cli.Context) error {
+			format := c.String("format")
+			if format != "table" && format != "json" {
+				return fmt.Errorf("unknown format '%s', expected 'table' or 'json'", format)
 			}
 
-			s, err := syncer.NewSyncer(logger, gClients, []string{gClientIDs}, iClient, c.Bool("dry-run"), loc)
+			status, err := syncer.LoadStatus(c.Int("runs"))
 			if err != nil {
-				return fmt.Errorf("failed to create syncer: %w", err)
+				return err
 			}
 
-			// --watch flag takes precedence
-			if c.IsSet("watch") {
-				interval := time.Duration(c.Int("watch")) * time.Second
-				logger.Info("Starting watcher.", "interval", interval)
-				ticker := time.NewTicker(interval)
-				defer ticker.Stop()
-				for ; true; <-ticker.C {
-					if err := s.Sync(c.Context); err != nil {
-						logger.Error("Sync cycle failed", "error", err)
-					}
-				}
-			} else { // --once is the default behavior if --watch is not set
-				logger.Info("Running a single sync cycle.")
-				if err := s.Sync(c.Context); err != nil {
-					return fmt.Errorf("single sync cycle failed: %w", err)
-				}
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(status)
+			}
+			printStatus(status)
+			return nil
+		},
+	}
+}
+
+// printStatus writes a human-readable summary of the sync status to stdout.
+func printStatus(status *syncer.Status) {
+	if len(status.Pipelines) == 0 {
+		fmt.Println("No sync runs recorded yet.")
+		return
+	}
+
+	formatTime := func(run *syncer.RunRecord) string {
+		if run == nil {
+			return "never"
+		}
+		return run.FinishedAt.Local().Format("2006-01-02 15:04:05")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PIPELINE\tEVENTS\tLAST RUN\tRESULT\tLAST SUCCESS")
+	var reauth []string
+	for _, ps := range status.Pipelines {
+		result := "-"
+		if ps.LastRun != nil {
+			result = "ok"
+			if !ps.LastRun.Succeeded() {
+				result = fmt.Sprintf("%d error(s)", len(ps.LastRun.Errors))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", ps.Pipeline, ps.SyncedEvents, formatTime(ps.LastRun), result, formatTime(ps.LastSuccess))
+		for _, acc := range ps.ReauthAccounts {
+			if !slices.Contains(reauth, acc) {
+				reauth = append(reauth, acc)
 			}
+		}
+	}
+	w.Flush()
 
+	if len(reauth) > 0 {
+		fmt.Printf("\nAccounts needing re-authentication (run 'syncal auth'): %s\n", strings.Join(reauth, ", "))
+	}
+
+	if len(status.Runs) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tDURATION\tPIPELINE\tFETCHED\tCREATED\tUPDATED\tDELETED\tFAILED\tCONFLICTS\tCOLLISIONS\tERRORS")
+	for _, run := range status.Runs {
+		fetched := 0
+		for _, n := range run.Fetched {
+			fetched += n
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			run.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond),
+			run.Pipeline, fetched, run.Created, run.Updated, run.Deleted, run.Failed, run.Conflicts, run.Collisions, len(run.Errors))
+	}
+	w.Flush()
+
+	if last := status.Runs[0]; !last.Succeeded() {
+		fmt.Println("\nErrors in the most recent run:")
+		for _, e := range last.Errors {
+			fmt.Printf("  - %s\n", e)
+		}
+	}
+}
+
+func conflictsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "conflicts",
+		Usage: "List events quarantined because they changed in both Google and iCloud.",
+		Action: func(c *cli.Context) error {
+			conflicts, err := syncer.ListConflicts()
+			if err != nil {
+				return err
+			}
+			if len(conflicts) == 0 {
+				fmt.Println("No quarantined conflicts.")
+				return nil
+			}
+			printConflicts(conflicts)
+			fmt.Println("\nResolve a conflict with: syncal conflicts resolve --keep google|icloud <uid>")
 			return nil
 		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "resolve",
+				Usage:     "Choose which version of a quarantined event to keep. It is applied on the next sync.",
+				ArgsUsage: "<uid or google event id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keep", Required: true, Usage: "The version to keep: 'google' (source) or 'icloud' (target)."},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return fmt.Errorf("expected exactly one uid or google event id")
+					}
+					if err := syncer.ResolveConflict(c.Args().First(), c.String("keep")); err != nil {
+						return err
+					}
+					fmt.Println("Resolution recorded, it will be applied on the next sync.")
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// printConflicts writes a human-readable table of conflicts to stdout.
+func printConflicts(conflicts []*syncer.Conflict) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PIPELINE\tTITLE\tGOOGLE\tICLOUD\tKEPT\tUID")
+	for _, c := range conflicts {
+		kept := c.Winner
+		if kept == "" {
+			kept = "quarantined"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Pipeline, c.Title, c.SourceAction, c.TargetAction, kept, c.UID)
+		for _, field := range c.Fields {
+			fmt.Fprintf(w, "\t  %s: google %q, icloud %q\n", field.Field, field.Old, field.New)
+		}
+	}
+	w.Flush()
+}
+
+// printPlan writes a human-readable table of the plan to stdout.
+func printPlan(plan *syncer.Plan, showUnchanged bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tTARGET\tSTART\tTITLE\tUID")
+	for _, change := range plan.Changes {
+		if change.Action == syncer.ActionUnchanged && !showUnchanged {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", change.Action, change.Target, change.Start.Format("2006-01-02 15:04"), change.Title, change.UID)
+		for _, field := range change.Fields {
+			fmt.Fprintf(w, "\t\t\t  %s: %q -> %q\n", field.Field, field.Old, field.New)
+		}
+	}
+	w.Flush()
+
+	if len(plan.Conflicts) > 0 {
+		fmt.Println("\nConflicts (changed in both Google and iCloud):")
+		printConflicts(plan.Conflicts)
+	}
+
+	fmt.Printf("\nPipeline %s: %d to create, %d to update, %d to delete, %d unchanged.\n",
+		plan.Pipeline,
+		plan.Count(syncer.ActionCreate),
+		plan.Count(syncer.ActionUpdate),
+		plan.Count(syncer.ActionDelete),
+		plan.Count(syncer.ActionUnchanged))
+}
+
+// primaryTimeZone returns the location events are normalized to.
+func primaryTimeZone() (*time.Location, error) {
+	tzStr := os.Getenv("PRIMARY_TIMEZONE")
+	if tzStr == "" {
+		tzStr = "UTC"
+	}
+	loc, err := time.LoadLocation(tzStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone '%s': %w", tzStr, err)
+	}
+	return loc, nil
+}
+
+// loggerFromEnv creates a logger using the level from the LOG_LEVEL environment variable.
+func loggerFromEnv() *slog.Logger {
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info"
 	}
+	return setupLogger(logLevel)
 }
 
 func setupLogger(level string) *slog.Logger {
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"syncal/internal/config"
+	"syncal/internal/filter"
+	"syncal/internal/google"
+	"syncal/internal/icloud"
+	"syncal/internal/ics"
+	"syncal/internal/outlook"
+	"syncal/internal/syncer"
+	"syncal/internal/transform"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// clientPool creates the Google, iCloud, CalDAV and Outlook clients needed by the pipelines, sharing them between pipelines.
+type clientPool struct {
+	c       *cli.Context
+	logger  *slog.Logger
+	google  map[string]*google.CalendarClient
+	icloud  map[string]*icloud.CalDAVClient
+	caldav  map[string]*icloud.CalDAVClient
+	outlook map[string]*outlook.Client
+}
+
+func newClientPool(c *cli.Context, logger *slog.Logger) *clientPool {
+	return &clientPool{
+		c:       c,
+		logger:  logger,
+		google:  make(map[string]*google.CalendarClient),
+		icloud:  make(map[string]*icloud.CalDAVClient),
+		caldav:  make(map[string]*icloud.CalDAVClient),
+		outlook: make(map[string]*outlook.Client),
+	}
+}
+
+// googleClient returns the client of the named Google account.
+func (p *clientPool) googleClient(account string) (*google.CalendarClient, error) {
+	if client, ok := p.google[account]; ok {
+		return client, nil
+	}
+	client, err := google.NewClient(p.c.Context, p.logger, os.Getenv("GOOGLE_CLIENT_ID"), os.Getenv("GOOGLE_CLIENT_SECRET"), account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create google client for account %s: %w", account, err)
+	}
+	p.google[account] = client
+	return client, nil
+}
+
+// googleClients returns the client of the named account, or of every authenticated account if the name is empty.
+func (p *clientPool) googleClients(account string) ([]*google.CalendarClient, error) {
+	accounts := []string{account}
+	if account == "" {
+		var err error
+		accounts, err = google.GetTokenAccounts()
+		if err != nil {
+			return nil, fmt.Errorf("could not find any google accounts, did you run auth command? %w", err)
+		}
+		if len(accounts) == 0 {
+			return nil, fmt.Errorf("no google accounts found. Run the 'auth' command first")
+		}
+	}
+
+	var clients []*google.CalendarClient
+	for _, acc := range accounts {
+		client, err := p.googleClient(acc)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// icloudClient returns the client of the named iCloud calendar.
+func (p *clientPool) icloudClient(calendar string) (*icloud.CalDAVClient, error) {
+	if client, ok := p.icloud[calendar]; ok {
+		return client, nil
+	}
+	client, err := icloud.NewClient(p.logger, os.Getenv("ICLOUD_USERNAME"), os.Getenv("ICLOUD_APP_SPECIFIC_PASSWORD"), calendar)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create icloud client: %w", err)
+	}
+	p.icloud[calendar] = client
+	return client, nil
+}
+
+// caldavClient returns the client of the calendar of a CalDAV endpoint.
+func (p *clientPool) caldavClient(e config.Endpoint) (*icloud.CalDAVClient, error) {
+	key := e.URL + "|" + e.Username + "|" + e.Calendar
+	if client, ok := p.caldav[key]; ok {
+		return client, nil
+	}
+	client, err := icloud.NewServerClient(p.logger, e.URL, e.Username, os.Getenv(e.PasswordEnv), e.Calendar)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create caldav client: %w", err)
+	}
+	p.caldav[key] = client
+	return client, nil
+}
+
+// outlookClient returns the client of the named Outlook account.
+func (p *clientPool) outlookClient(account string) (*outlook.Client, error) {
+	if client, ok := p.outlook[account]; ok {
+		return client, nil
+	}
+	client, err := outlook.NewClient(p.c.Context, p.logger, os.Getenv("MS_CLIENT_ID"), os.Getenv("MS_TENANT_ID"), os.Getenv("MS_GRAPH_URL"), account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outlook client for account %s: %w", account, err)
+	}
+	p.outlook[account] = client
+	return client, nil
+}
+
+// outlookClients returns the client of the named account, or of every authenticated account if the name is empty.
+func (p *clientPool) outlookClients(account string) ([]*outlook.Client, error) {
+	accounts := []string{account}
+	if account == "" {
+		var err error
+		accounts, err = outlook.GetTokenAccounts()
+		if err != nil {
+			return nil, fmt.Errorf("could not find any outlook accounts, did you run auth command? %w", err)
+		}
+		if len(accounts) == 0 {
+			return nil, fmt.Errorf("no outlook accounts found. Run the 'auth --provider outlook' command first")
+		}
+	}
+
+	var clients []*outlook.Client
+	for _, acc := range accounts {
+		client, err := p.outlookClient(acc)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// newPipelines creates every configured pipeline, or only the named one, with a syncer for each of its targets.
+func newPipelines(c *cli.Context, logger *slog.Logger, only string, dryRun bool) ([]*syncer.Pipeline, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := primaryTimeZone()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := newClientPool(c, logger)
+	var pipelines []*syncer.Pipeline
+	for _, p := range cfg.Pipelines {
+		if only != "" && p.Name != only {
+			continue
+		}
+		pipeline, err := newPipeline(pool, p, dryRun, loc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create syncer for pipeline '%s': %w", p.Name, err)
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	if len(pipelines) == 0 {
+		return nil, fmt.Errorf("no pipeline named '%s'", only)
+	}
+	return pipelines, nil
+}
+
+// newPurgeSyncers creates a syncer for every target of the configured pipelines, or only for the targets of the
+// named pipeline, to purge the events written to them. A single target of a pipeline with several targets is
+// named "<pipeline>/<target>".
+func newPurgeSyncers(c *cli.Context, logger *slog.Logger, only string, dryRun bool) ([]*syncer.Syncer, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := primaryTimeZone()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := newClientPool(c, logger)
+	var syncers []*syncer.Syncer
+	for _, p := range cfg.Pipelines {
+		for _, target := range p.AllTargets() {
+			if only != "" && p.Name != only && p.StateName(target) != only {
+				continue
+			}
+			// Purging needs no source.
+			s, err := newTargetSyncer(pool, p, target, nil, dryRun, loc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create syncer for pipeline '%s': %w", p.StateName(target), err)
+			}
+			syncers = append(syncers, s)
+		}
+	}
+	if len(syncers) == 0 {
+		return nil, fmt.Errorf("no pipeline named '%s'", only)
+	}
+	return syncers, nil
+}
+
+// newPipeline creates the source of a pipeline and a syncer for each of its targets.
+func newPipeline(pool *clientPool, p *config.Pipeline, dryRun bool, loc *time.Location) (*syncer.Pipeline, error) {
+	source, err := newPipelineSource(pool, p)
+	if err != nil {
+		return nil, err
+	}
+	var syncers []*syncer.Syncer
+	for _, target := range p.AllTargets() {
+		s, err := newTargetSyncer(pool, p, target, source, dryRun, loc)
+		if err != nil {
+			return nil, fmt.Errorf("target '%s': %w", target.Name, err)
+		}
+		syncers = append(syncers, s)
+	}
+	return syncer.NewPipeline(pool.logger, p.Name, source, syncers), nil
+}
+
+// newTargetSyncer creates the writer of a pipeline target and the syncer connecting the source to it.
+func newTargetSyncer(pool *clientPool, p *config.Pipeline, target config.Endpoint, source syncer.Source, dryRun bool, loc *time.Location) (*syncer.Syncer, error) {
+	policy, err := syncer.ParseConflictPolicy(p.ConflictPolicy)
+	if err != nil {
+		return nil, err
+	}
+	links, err := syncer.ParseConferenceLinks(p.ConferenceLinks)
+	if err != nil {
+		return nil, err
+	}
+	f, err := filter.New(p.FilterRules())
+	if err != nil {
+		return nil, err
+	}
+	t, err := transform.New(p.Transform)
+	if err != nil {
+		return nil, err
+	}
+	opts := syncer.Options{
+		Pipeline:                 p.StateName(target),
+		DryRun:                   dryRun,
+		TimeZone:                 loc,
+		ConflictPolicy:           policy,
+		Filter:                   f,
+		Transform:                t,
+		MarkTentative:            p.MarkTentative,
+		OmitAttendees:            p.OmitAttendees,
+		ConferenceLinks:          links,
+		Colors:                   colors(p),
+		Reminders:                reminders(p),
+		PlainDescriptions:        p.PlainDescriptions,
+		MaxDescriptionLength:     p.MaxDescriptionLength,
+		AttachmentsInDescription: p.AttachmentsInDescription,
+		Redaction:                redaction(p),
+	}
+
+	if p.TwoWay {
+		clients, err := pool.googleClients(p.Source.Account)
+		if err != nil {
+			return nil, err
+		}
+		opts.TwoWay = &syncer.TwoWay{Google: clients, WriteCalendar: p.WriteCalendar}
+	}
+
+	var writer syncer.Writer
+	switch target.Type {
+	case config.TypeGoogle:
+		client, err := pool.googleClient(target.Account)
+		if err != nil {
+			return nil, err
+		}
+		writer = client.Writer(target.Calendars[0])
+	case config.TypeICloud:
+		client, err := pool.icloudClient(target.Calendar)
+		if err != nil {
+			return nil, err
+		}
+		writer = client
+	case config.TypeCalDAV:
+		client, err := pool.caldavClient(target)
+		if err != nil {
+			return nil, err
+		}
+		writer = client
+	case config.TypeICS:
+		writer = ics.NewFileWriter(pool.logger, target.Path)
+	case config.TypeVdir:
+		writer = ics.NewDirWriter(pool.logger, target.Path)
+	case config.TypeOutlook:
+		client, err := pool.outlookClient(target.Account)
+		if err != nil {
+			return nil, err
+		}
+		writer = client.Writer(target.Calendars[0])
+	}
+
+	return syncer.NewSyncer(pool.logger, source, writer, opts)
+}
+
+// newPipelineSource creates the source a pipeline reads events from.
+func newPipelineSource(pool *clientPool, p *config.Pipeline) (syncer.Source, error) {
+	switch p.Source.Type {
+	case config.TypeGoogle:
+		clients, err := pool.googleClients(p.Source.Account)
+		if err != nil {
+			return nil, err
+		}
+		return syncer.NewGoogleSource(pool.logger, clients, p.Source.Calendars), nil
+	case config.TypeICloud:
+		client, err := pool.icloudClient(p.Source.Calendar)
+		if err != nil {
+			return nil, err
+		}
+		return syncer.NewICloudSource(pool.logger, client), nil
+	case config.TypeICS:
+		return syncer.NewICSSource(pool.logger, ics.NewFeed(pool.logger, p.Source.URL, p.Source.Calendar)), nil
+	case config.TypeOutlook:
+		clients, err := pool.outlookClients(p.Source.Account)
+		if err != nil {
+			return nil, err
+		}
+		return syncer.NewOutlookSource(pool.logger, clients, p.Source.Calendars), nil
+	case config.TypeCalDAV:
+		client, err := pool.caldavClient(p.Source)
+		if err != nil {
+			return nil, err
+		}
+		return syncer.NewCalDAVSource(pool.logger, client), nil
+	}
+	return nil, fmt.Errorf("unsupported source type '%s'", p.Source.Type)
+}
+
+// newFeeds creates a feed of the events of every configured pipeline, or only of the named one.
+// The targets of the pipelines are not used.
+func newFeeds(c *cli.Context, logger *slog.Logger, only string) ([]*syncer.Feed, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := primaryTimeZone()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := newClientPool(c, logger)
+	var feeds []*syncer.Feed
+	for _, p := range cfg.Pipelines {
+		if only != "" && p.Name != only {
+			continue
+		}
+		source, err := newPipelineSource(pool, p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create source for pipeline '%s': %w", p.Name, err)
+		}
+		f, err := filter.New(p.FilterRules())
+		if err != nil {
+			return nil, err
+		}
+		t, err := transform.New(p.Transform)
+		if err != nil {
+			return nil, err
+		}
+		links, err := syncer.ParseConferenceLinks(p.ConferenceLinks)
+		if err != nil {
+			return nil, err
+		}
+		opts := syncer.Options{
+			Pipeline:                 p.Name,
+			TimeZone:                 loc,
+			Filter:                   f,
+			Transform:                t,
+			MarkTentative:            p.MarkTentative,
+			OmitAttendees:            p.OmitAttendees,
+			ConferenceLinks:          links,
+			Colors:                   colors(p),
+			Reminders:                reminders(p),
+			PlainDescriptions:        p.PlainDescriptions,
+			MaxDescriptionLength:     p.MaxDescriptionLength,
+			AttachmentsInDescription: p.AttachmentsInDescription,
+			Redaction:                redaction(p),
+		}
+		feeds = append(feeds, syncer.NewFeed(logger, source, opts))
+	}
+	if len(feeds) == 0 {
+		return nil, fmt.Errorf("no pipeline named '%s'", only)
+	}
+	return feeds, nil
+}
+
+// colors returns the color settings of a pipeline. Colors of categories are matched without case.
+func colors(p *config.Pipeline) syncer.Colors {
+	if p.Colors == nil {
+		return syncer.Colors{}
+	}
+	c := syncer.Colors{Color: p.Colors.Color, Calendars: p.Colors.Calendars}
+	if len(p.Colors.Categories) > 0 {
+		c.Categories = make(map[string]string)
+		for color, category := range p.Colors.Categories {
+			c.Categories[strings.ToLower(color)] = category
+		}
+	}
+	return c
+}
+
+// reminders returns the reminders that replace those of the events of a pipeline, nil to keep them.
+func reminders(p *config.Pipeline) []int {
+	if p.Reminders == nil {
+		return nil
+	}
+	if p.Reminders.Minutes == nil {
+		return []int{} // "minutes" left out or null, which removes the reminders like an empty list
+	}
+	return p.Reminders.Minutes
+}
+
+// redaction returns the redaction settings of a pipeline.
+func redaction(p *config.Pipeline) syncer.Redaction {
+	if p.Redact == nil {
+		return syncer.Redaction{}
+	}
+	return syncer.Redaction{All: true, Title: p.Redact.Title}
+}
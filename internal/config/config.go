@@ -0,0 +1,474 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syncal/internal/filter"
+	"syncal/internal/transform"
+)
+
+// DefaultFile is the pipelines file read when SYNCAL_CONFIG is not set.
+const DefaultFile = "syncal.json"
+
+// Endpoint types.
+const (
+	TypeGoogle  = "google"
+	TypeICloud  = "icloud"
+	TypeICS     = "ics"
+	TypeVdir    = "vdir"
+	TypeOutlook = "outlook"
+	TypeCalDAV  = "caldav"
+)
+
+// Sync directions accepted in SYNC_DIRECTION.
+const (
+	DirectionGoogleToICloud = "google-to-icloud"
+	DirectionTwoWay         = "two-way"
+	DirectionICloudToGoogle = "icloud-to-google"
+)
+
+// Config lists the sync pipelines to run.
+type Config struct {
+	Pipelines []*Pipeline `json:"pipelines"`
+}
+
+// Pipeline moves events from a source calendar to a target calendar, or to several.
+type Pipeline struct {
+	Name   string   `json:"name"`
+	Source Endpoint `json:"source"`
+	Target Endpoint `json:"target"`
+	// Targets replaces Target to sync the source to several calendars. Each target has its own sync state.
+	Targets []Endpoint `json:"targets,omitempty"`
+	// TwoWay also copies events created or changed in the iCloud target back to Google.
+	TwoWay bool `json:"twoWay,omitempty"`
+	// WriteCalendar is the Google "account/calendarID" that receives events created in iCloud in two-way sync.
+	WriteCalendar  string `json:"writeCalendar,omitempty"`
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+	// Filters select the source events the pipeline syncs.
+	Filters filter.Rules `json:"filters,omitempty"`
+	// SkipDeclined leaves out invitations the owner of the source calendar declined.
+	SkipDeclined bool `json:"skipDeclined,omitempty"`
+	// MarkTentative writes invitations that weren't answered yet, or accepted tentatively, as tentative events.
+	MarkTentative bool `json:"markTentative,omitempty"`
+	// OmitAttendees writes events without their guests, so that the target doesn't send them invitations.
+	OmitAttendees bool `json:"omitAttendees,omitempty"`
+	// ConferenceLinks decides how links to video calls are written: "url" (the default), "description",
+	// "both" or "none".
+	ConferenceLinks string `json:"conferenceLinks,omitempty"`
+	// Redact, when set, hides the details of every event behind a placeholder title.
+	// Events marked private in their source are redacted either way.
+	Redact *Redact `json:"redact,omitempty"`
+	// Transform rewrites the fields of events with templates before they are written.
+	Transform transform.Templates `json:"transform,omitempty"`
+	// PlainDescriptions writes descriptions as plain text only, without the HTML version Google descriptions have.
+	PlainDescriptions bool `json:"plainDescriptions,omitempty"`
+	// MaxDescriptionLength is the number of characters descriptions are cut to, 10000 by default.
+	MaxDescriptionLength int `json:"maxDescriptionLength,omitempty"`
+	// AttachmentsInDescription also lists the attachments of events at the end of their descriptions,
+	// for calendars that ignore attachments.
+	AttachmentsInDescription bool `json:"attachmentsInDescription,omitempty"`
+	// Colors, when set, override the colors of events and map colors to categories.
+	Colors *Colors `json:"colors,omitempty"`
+	// Reminders, when set, replace the reminders of the source events.
+	Reminders *Reminders `json:"reminders,omitempty"`
+}
+
+// Redact configures the redaction of events.
+type Redact struct {
+	// Title replaces the titles of events, "Busy" by default.
+	Title string `json:"title,omitempty"`
+}
+
+// Colors configures the colors of the events a pipeline writes. Colors are CSS color names, like "orange".
+type Colors struct {
+	// Color is the color of every event, instead of the color it has in its source.
+	Color string `json:"color,omitempty"`
+	// Calendars are the colors of the events of source calendars, by calendar ID or "account/calendarID".
+	Calendars map[string]string `json:"calendars,omitempty"`
+	// Categories add a category to the events of a color, e.g. {"tomato": "Urgent"}.
+	Categories map[string]string `json:"categories,omitempty"`
+}
+
+// Reminders configures the alerts of the events a pipeline writes.
+type Reminders struct {
+	// Minutes are the alerts of every event, in minutes before its start. An empty list removes all alerts.
+	Minutes []int `json:"minutes"`
+}
+
+// Endpoint is a calendar service a pipeline reads from or writes to.
+type Endpoint struct {
+	Type string `json:"type"`
+	// Name tells the targets of a pipeline apart. It defaults to the type.
+	Name string `json:"name,omitempty"`
+	// Account is the name of the Google or Outlook account, as given to the 'auth' command.
+	// For a Google or Outlook source, an empty account reads the calendars from every authenticated account.
+	Account string `json:"account,omitempty"`
+	// Calendars are the Google or Outlook calendar IDs to read. A Google or Outlook target takes exactly one.
+	// For Outlook, "primary" is the default calendar of the account.
+	Calendars []string `json:"calendars,omitempty"`
+	// Calendar is the name of the iCloud or CalDAV calendar. For iCloud, it defaults to ICLOUD_CALENDAR_NAME.
+	// For an ICS feed, it is the name shown in logs and status instead of the URL.
+	Calendar string `json:"calendar,omitempty"`
+	// URL is the address or local path of an ICS feed, or the URL of a CalDAV server.
+	URL string `json:"url,omitempty"`
+	// Username is the CalDAV user name.
+	Username string `json:"username,omitempty"`
+	// PasswordEnv names the environment variable holding the CalDAV password, so that it
+	// doesn't have to be stored in the pipelines file. It defaults to CALDAV_PASSWORD.
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	// Path is the .ics file of an ics target, or the directory of a vdir target.
+	Path string `json:"path,omitempty"`
+}
+
+// Load returns the configuration from the pipelines file named by SYNCAL_CONFIG, or DefaultFile.
+// Without a pipelines file, a single pipeline is configured from the environment.
+func Load() (*Config, error) {
+	path := os.Getenv("SYNCAL_CONFIG")
+	if path == "" {
+		path = DefaultFile
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && os.Getenv("SYNCAL_CONFIG") == "" {
+		return FromEnv()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	for _, p := range cfg.Pipelines {
+		p.Source.setDefaults()
+		p.Target.setDefaults()
+		for i := range p.Targets {
+			p.Targets[i].setDefaults()
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// setDefaults fills in the settings of an endpoint that default to the environment.
+func (e *Endpoint) setDefaults() {
+	if e.Name == "" {
+		e.Name = e.Type
+	}
+	if e.Type == TypeICloud && e.Calendar == "" {
+		e.Calendar = os.Getenv("ICLOUD_CALENDAR_NAME")
+	}
+	if e.Type == TypeCalDAV && e.PasswordEnv == "" {
+		e.PasswordEnv = "CALDAV_PASSWORD"
+	}
+}
+
+// AllTargets returns the targets of the pipeline: Targets if set, otherwise Target.
+func (p *Pipeline) AllTargets() []Endpoint {
+	if len(p.Targets) > 0 {
+		return p.Targets
+	}
+	return []Endpoint{p.Target}
+}
+
+// FilterRules returns the filters of the pipeline, including the rule SkipDeclined stands for.
+func (p *Pipeline) FilterRules() filter.Rules {
+	rules := p.Filters
+	if p.SkipDeclined {
+		rules.Exclude = append(slices.Clone(rules.Exclude), filter.Rule{ResponseStatus: []string{"declined"}})
+	}
+	return rules
+}
+
+// StateName returns the name the sync state of a target of the pipeline is kept under, which is also
+// recorded on the events written to it: the pipeline name for a single target, or
+// "<pipeline>/<target name>" for each of several targets.
+func (p *Pipeline) StateName(target Endpoint) string {
+	if len(p.Targets) == 0 {
+		return p.Name
+	}
+	return p.Name + "/" + target.Name
+}
+
+// FromEnv configures a single pipeline from SYNCAL_PIPELINE, SYNC_DIRECTION, GOOGLE_CALENDAR_IDS,
+// GOOGLE_WRITE_CALENDAR, ICLOUD_CALENDAR_NAME, CONFLICT_POLICY, SKIP_DECLINED, MARK_TENTATIVE, OMIT_ATTENDEES,
+// CONFERENCE_LINKS, PLAIN_DESCRIPTIONS, MAX_DESCRIPTION_LENGTH, ATTACHMENTS_IN_DESCRIPTION, EVENT_COLOR,
+// REDACT_TITLE and REMINDER_MINUTES.
+func FromEnv() (*Config, error) {
+	p := &Pipeline{
+		Name:           os.Getenv("SYNCAL_PIPELINE"),
+		ConflictPolicy: os.Getenv("CONFLICT_POLICY"),
+	}
+	if p.Name == "" {
+		p.Name = "default" // Same as syncer.DefaultPipeline, so existing sync state keeps working
+	}
+	p.SkipDeclined = os.Getenv("SKIP_DECLINED") == "true"
+	p.MarkTentative = os.Getenv("MARK_TENTATIVE") == "true"
+	p.OmitAttendees = os.Getenv("OMIT_ATTENDEES") == "true"
+	p.ConferenceLinks = os.Getenv("CONFERENCE_LINKS")
+	p.PlainDescriptions = os.Getenv("PLAIN_DESCRIPTIONS") == "true"
+	if length := os.Getenv("MAX_DESCRIPTION_LENGTH"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAX_DESCRIPTION_LENGTH '%s'", length)
+		}
+		p.MaxDescriptionLength = n
+	}
+	p.AttachmentsInDescription = os.Getenv("ATTACHMENTS_IN_DESCRIPTION") == "true"
+	if color := os.Getenv("EVENT_COLOR"); color != "" {
+		p.Colors = &Colors{Color: color}
+	}
+	if title := os.Getenv("REDACT_TITLE"); title != "" {
+		p.Redact = &Redact{Title: title}
+	}
+	if minutes := os.Getenv("REMINDER_MINUTES"); minutes != "" {
+		reminders, err := parseReminders(minutes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REMINDER_MINUTES: %w", err)
+		}
+		p.Reminders = reminders
+	}
+	icloudCalendar := Endpoint{Type: TypeICloud, Calendar: os.Getenv("ICLOUD_CALENDAR_NAME")}
+	var googleCalendars []string
+	if ids := os.Getenv("GOOGLE_CALENDAR_IDS"); ids != "" {
+		googleCalendars = strings.Split(ids, ",")
+	}
+
+	switch direction := os.Getenv("SYNC_DIRECTION"); direction {
+	case "", DirectionGoogleToICloud, DirectionTwoWay:
+		if len(googleCalendars) == 0 {
+			return nil, fmt.Errorf("GOOGLE_CALENDAR_IDS environment variable not set")
+		}
+		p.Source = Endpoint{Type: TypeGoogle, Calendars: googleCalendars}
+		p.Target = icloudCalendar
+		p.TwoWay = direction == DirectionTwoWay
+		p.WriteCalendar = os.Getenv("GOOGLE_WRITE_CALENDAR")
+	case DirectionICloudToGoogle:
+		account, calID, _ := strings.Cut(os.Getenv("GOOGLE_WRITE_CALENDAR"), "/")
+		p.Source = icloudCalendar
+		p.Target = Endpoint{Type: TypeGoogle, Account: account, Calendars: []string{calID}}
+	default:
+		return nil, fmt.Errorf("unknown sync direction '%s'", direction)
+	}
+
+	cfg := &Config{Pipelines: []*Pipeline{p}}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseReminders parses a comma-separated list of minutes, or "none" for no reminders.
+func parseReminders(s string) (*Reminders, error) {
+	reminders := &Reminders{Minutes: []int{}}
+	if s == "none" {
+		return reminders, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		minutes, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("expected minutes like 10,60 or 'none', got '%s'", s)
+		}
+		reminders.Minutes = append(reminders.Minutes, minutes)
+	}
+	return reminders, nil
+}
+
+// Validate checks that every pipeline is complete, that pipeline names are unique and that no two
+// targets write to the same .ics file.
+func (c *Config) Validate() error {
+	if len(c.Pipelines) == 0 {
+		return fmt.Errorf("no pipelines configured")
+	}
+	seen := make(map[string]bool)
+	files := make(map[string]string) // Path of every ics target -> pipeline writing it
+	for _, p := range c.Pipelines {
+		if p.Name == "" {
+			return fmt.Errorf("pipeline without a name")
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate pipeline name '%s'", p.Name)
+		}
+		seen[p.Name] = true
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("pipeline '%s': %w", p.Name, err)
+		}
+		for _, target := range p.AllTargets() {
+			if target.Type != TypeICS {
+				continue
+			}
+			// The file is written once per cycle from what it held at the start, so a second writer's changes would be lost.
+			path := filepath.Clean(target.Path)
+			if other, ok := files[path]; ok {
+				return fmt.Errorf("pipeline '%s': ics target %s is already written by pipeline '%s'", p.Name, target.Path, other)
+			}
+			files[path] = p.Name
+		}
+	}
+	return nil
+}
+
+// validate checks a single pipeline.
+func (p *Pipeline) validate() error {
+	switch p.Source.Type {
+	case TypeGoogle, TypeOutlook:
+		if len(p.Source.Calendars) == 0 {
+			return fmt.Errorf("%s source needs at least one calendar", p.Source.Type)
+		}
+	case TypeICloud:
+		if p.Source.Calendar == "" {
+			return fmt.Errorf("icloud source needs a calendar name")
+		}
+	case TypeICS:
+		if p.Source.URL == "" {
+			return fmt.Errorf("ics source needs a url")
+		}
+	case TypeCalDAV:
+		if p.Source.URL == "" || p.Source.Username == "" || p.Source.Calendar == "" {
+			return fmt.Errorf("caldav source needs a url, a username and a calendar name")
+		}
+	default:
+		return fmt.Errorf("unknown source type '%s'", p.Source.Type)
+	}
+
+	if len(p.Targets) > 0 && p.Target.Type != "" {
+		return fmt.Errorf("pipeline has both a target and targets")
+	}
+	names := make(map[string]bool)
+	for _, target := range p.AllTargets() {
+		if names[target.Name] {
+			return fmt.Errorf("duplicate target name '%s', targets of the same type need a name", target.Name)
+		}
+		names[target.Name] = true
+		if err := p.validateTarget(target); err != nil {
+			return err
+		}
+	}
+
+	if _, err := filter.New(p.FilterRules()); err != nil {
+		return fmt.Errorf("invalid filters: %w", err)
+	}
+	if _, err := transform.New(p.Transform); err != nil {
+		return fmt.Errorf("invalid transform: %w", err)
+	}
+
+	if p.MaxDescriptionLength < 0 {
+		return fmt.Errorf("maxDescriptionLength can't be negative")
+	}
+	if p.Colors != nil {
+		if err := p.Colors.validate(); err != nil {
+			return err
+		}
+	}
+	if p.Reminders != nil {
+		for _, minutes := range p.Reminders.Minutes {
+			if minutes < 0 {
+				return fmt.Errorf("reminders can't be after the start of events, got %d minutes", minutes)
+			}
+		}
+	}
+
+	if p.TwoWay {
+		if p.Source.Type != TypeGoogle || len(p.Targets) > 0 || p.Target.Type != TypeICloud {
+			return fmt.Errorf("two-way sync is only supported from google to a single icloud target")
+		}
+		account, calID, ok := strings.Cut(p.WriteCalendar, "/")
+		if !ok || account == "" || calID == "" {
+			return fmt.Errorf("two-way sync needs a Google write calendar in the form 'account/calendarID', got '%s'", p.WriteCalendar)
+		}
+		// Edits to redacted or transformed copies would overwrite the details in Google.
+		if p.Redact != nil {
+			return fmt.Errorf("two-way sync can't be combined with redaction")
+		}
+		if p.Transform != (transform.Templates{}) {
+			return fmt.Errorf("two-way sync can't be combined with transforms")
+		}
+		// Filtering out the Google copy of an event created in iCloud would delete the iCloud original.
+		if rules := p.FilterRules(); len(rules.Include) > 0 || len(rules.Exclude) > 0 {
+			return fmt.Errorf("two-way sync can't be combined with filters or skipDeclined")
+		}
+		// Two-way syncers never cut descriptions, whatever maxDescriptionLength says.
+		if p.PlainDescriptions {
+			return fmt.Errorf("two-way sync can't be combined with plainDescriptions")
+		}
+	}
+	return nil
+}
+
+// validate checks that the colors are CSS color names.
+func (c *Colors) validate() error {
+	colors := []string{c.Color}
+	for _, color := range c.Calendars {
+		colors = append(colors, color)
+	}
+	for color := range c.Categories {
+		colors = append(colors, color)
+	}
+	for _, color := range colors {
+		if strings.Trim(strings.ToLower(color), "abcdefghijklmnopqrstuvwxyz") != "" {
+			return fmt.Errorf("invalid color '%s', expected a CSS color name like 'orange'", color)
+		}
+	}
+	return nil
+}
+
+// validateTarget checks a single target of the pipeline.
+func (p *Pipeline) validateTarget(target Endpoint) error {
+	switch target.Type {
+	case TypeGoogle, TypeOutlook:
+		if target.Account == "" || len(target.Calendars) != 1 || target.Calendars[0] == "" {
+			return fmt.Errorf("%s target needs an account and exactly one calendar", target.Type)
+		}
+		// Reading the target calendar would copy its events onto themselves.
+		if p.Source.Type == target.Type && (p.Source.Account == "" || p.Source.Account == target.Account) &&
+			slices.Contains(p.Source.Calendars, target.Calendars[0]) {
+			return fmt.Errorf("%s source must not include the target calendar %s/%s", p.Source.Type, target.Account, target.Calendars[0])
+		}
+	case TypeICloud:
+		if target.Calendar == "" {
+			return fmt.Errorf("icloud target needs a calendar name")
+		}
+	case TypeCalDAV:
+		if target.URL == "" || target.Username == "" || target.Calendar == "" {
+			return fmt.Errorf("caldav target needs a url, a username and a calendar name")
+		}
+	case TypeICS, TypeVdir:
+		if target.Path == "" {
+			return fmt.Errorf("%s target needs a path", target.Type)
+		}
+	default:
+		return fmt.Errorf("unknown target type '%s'", target.Type)
+	}
+	return nil
+}
+
+// WriteAccounts returns the accounts of the given type (TypeGoogle or TypeOutlook) that pipelines
+// write to, which need write access.
+func (c *Config) WriteAccounts(accountType string) []string {
+	var accounts []string
+	add := func(account string) {
+		if account != "" && !slices.Contains(accounts, account) {
+			accounts = append(accounts, account)
+		}
+	}
+	for _, p := range c.Pipelines {
+		for _, target := range p.AllTargets() {
+			if target.Type == accountType {
+				add(target.Account)
+			}
+		}
+		if p.TwoWay && accountType == TypeGoogle {
+			account, _, _ := strings.Cut(p.WriteCalendar, "/")
+			add(account)
+		}
+	}
+	return accounts
+}
@@ -0,0 +1,202 @@
+// Package filter decides which events a pipeline syncs, from declarative include and exclude rules.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"syncal/internal/models"
+	"time"
+)
+
+// Rules are the filters of a pipeline. An event is synced if it matches any include rule,
+// or there are none, and no exclude rule.
+type Rules struct {
+	Include []Rule `json:"include,omitempty"`
+	Exclude []Rule `json:"exclude,omitempty"`
+}
+
+// Rule matches the events that meet every condition it sets. A rule without conditions matches every event.
+type Rule struct {
+	// Title is a regular expression the title must match, e.g. "(?i)^(lunch|focus time)$".
+	Title string `json:"title,omitempty"`
+	// Calendars are the source calendars, as calendar IDs or "account/calendarID".
+	Calendars []string `json:"calendars,omitempty"`
+	// Organizer is a regular expression the organizer's email must match, e.g. "@example\\.com$".
+	Organizer string `json:"organizer,omitempty"`
+	// ResponseStatus lists the accepted responses to the invitation: "needsAction", "accepted", "tentative"
+	// or "declined". Events without attendees have no response and never match.
+	ResponseStatus []string `json:"responseStatus,omitempty"`
+	// EventTypes lists the accepted kinds of events, e.g. "default", "focusTime" or "outOfOffice".
+	EventTypes []string `json:"eventTypes,omitempty"`
+	// Visibility lists the accepted visibilities: "default", "public", "private" or "confidential".
+	Visibility []string `json:"visibility,omitempty"`
+	// MinDuration and MaxDuration bound the length of the event, as Go durations such as "15m" or "8h".
+	MinDuration string `json:"minDuration,omitempty"`
+	MaxDuration string `json:"maxDuration,omitempty"`
+	// StartsAfter and StartsBefore bound the time of day the event starts at, as "15:04" in the primary
+	// time zone. StartsAfter is inclusive and StartsBefore exclusive; if StartsBefore is earlier than
+	// StartsAfter, the range wraps around midnight.
+	StartsAfter  string `json:"startsAfter,omitempty"`
+	StartsBefore string `json:"startsBefore,omitempty"`
+}
+
+// Filter is a compiled set of Rules. A nil Filter matches every event.
+type Filter struct {
+	include []*rule
+	exclude []*rule
+}
+
+// rule is a compiled Rule.
+type rule struct {
+	title          *regexp.Regexp
+	calendars      []string
+	organizer      *regexp.Regexp
+	responseStatus []string
+	eventTypes     []string
+	visibility     []string
+	minDuration    time.Duration
+	maxDuration    time.Duration
+	startsAfter    time.Duration // Since midnight, -1 if not set
+	startsBefore   time.Duration
+}
+
+// New compiles the rules, returning nil if there are none.
+func New(rules Rules) (*Filter, error) {
+	if len(rules.Include) == 0 && len(rules.Exclude) == 0 {
+		return nil, nil
+	}
+	f := &Filter{}
+	for i, r := range rules.Include {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("include rule %d: %w", i+1, err)
+		}
+		f.include = append(f.include, compiled)
+	}
+	for i, r := range rules.Exclude {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("exclude rule %d: %w", i+1, err)
+		}
+		f.exclude = append(f.exclude, compiled)
+	}
+	return f, nil
+}
+
+// Match reports whether the event passes the filter. Times of day are those of the event's
+// start time, so events should already be in the primary time zone.
+func (f *Filter) Match(event *models.Event) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !slices.ContainsFunc(f.include, func(r *rule) bool { return r.match(event) }) {
+		return false
+	}
+	return !slices.ContainsFunc(f.exclude, func(r *rule) bool { return r.match(event) })
+}
+
+// compile checks a rule and prepares it for matching.
+func compile(r Rule) (*rule, error) {
+	c := &rule{
+		calendars:      r.Calendars,
+		responseStatus: r.ResponseStatus,
+		eventTypes:     r.EventTypes,
+		visibility:     r.Visibility,
+		startsAfter:    -1,
+		startsBefore:   -1,
+	}
+	var err error
+	if r.Title != "" {
+		if c.title, err = regexp.Compile(r.Title); err != nil {
+			return nil, fmt.Errorf("invalid title pattern: %w", err)
+		}
+	}
+	if r.Organizer != "" {
+		if c.organizer, err = regexp.Compile(r.Organizer); err != nil {
+			return nil, fmt.Errorf("invalid organizer pattern: %w", err)
+		}
+	}
+	if r.MinDuration != "" {
+		if c.minDuration, err = time.ParseDuration(r.MinDuration); err != nil {
+			return nil, fmt.Errorf("invalid minDuration: %w", err)
+		}
+	}
+	if r.MaxDuration != "" {
+		if c.maxDuration, err = time.ParseDuration(r.MaxDuration); err != nil {
+			return nil, fmt.Errorf("invalid maxDuration: %w", err)
+		}
+	}
+	if r.StartsAfter != "" {
+		if c.startsAfter, err = parseTimeOfDay(r.StartsAfter); err != nil {
+			return nil, fmt.Errorf("invalid startsAfter: %w", err)
+		}
+	}
+	if r.StartsBefore != "" {
+		if c.startsBefore, err = parseTimeOfDay(r.StartsBefore); err != nil {
+			return nil, fmt.Errorf("invalid startsBefore: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// match reports whether the event meets every condition of the rule.
+func (r *rule) match(event *models.Event) bool {
+	if r.title != nil && !r.title.MatchString(event.Title) {
+		return false
+	}
+	if len(r.calendars) > 0 && !slices.Contains(r.calendars, event.CalendarID) &&
+		!slices.Contains(r.calendars, event.Account+"/"+event.CalendarID) {
+		return false
+	}
+	if r.organizer != nil && !r.organizer.MatchString(event.Organizer) {
+		return false
+	}
+	if len(r.responseStatus) > 0 && !containsFold(r.responseStatus, event.ResponseStatus) {
+		return false
+	}
+	if len(r.eventTypes) > 0 && !containsFold(r.eventTypes, event.EventType) {
+		return false
+	}
+	if len(r.visibility) > 0 && !containsFold(r.visibility, event.Visibility) {
+		return false
+	}
+
+	duration := event.EndTime.Sub(event.StartTime)
+	if r.minDuration > 0 && duration < r.minDuration {
+		return false
+	}
+	if r.maxDuration > 0 && duration > r.maxDuration {
+		return false
+	}
+
+	if r.startsAfter >= 0 || r.startsBefore >= 0 {
+		start := event.StartTime
+		tod := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute + time.Duration(start.Second())*time.Second
+		switch {
+		case r.startsAfter >= 0 && r.startsBefore >= 0 && r.startsBefore < r.startsAfter:
+			// The range wraps around midnight, e.g. from 22:00 to 06:00.
+			if tod < r.startsAfter && tod >= r.startsBefore {
+				return false
+			}
+		case r.startsAfter >= 0 && tod < r.startsAfter, r.startsBefore >= 0 && tod >= r.startsBefore:
+			return false
+		}
+	}
+	return true
+}
+
+// parseTimeOfDay parses "15:04" into the time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected a time of day like 09:30, got '%s'", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// containsFold reports whether values contains s, ignoring case.
+func containsFold(values []string, s string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, s) })
+}
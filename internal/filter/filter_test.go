@@ -0,0 +1,129 @@
+package filter
+
+import (
+	"strings"
+	"syncal/internal/models"
+	"testing"
+	"time"
+)
+
+// event returns an event of the work/primary calendar that starts at the given time of day and lasts d.
+func event(title, startsAt string, d time.Duration) *models.Event {
+	start, err := time.Parse("2006-01-02 15:04", "2026-03-02 "+startsAt)
+	if err != nil {
+		panic(err)
+	}
+	return &models.Event{
+		Title:          title,
+		StartTime:      start,
+		EndTime:        start.Add(d),
+		Account:        "work",
+		CalendarID:     "primary",
+		Organizer:      "lead@example.com",
+		ResponseStatus: "accepted",
+		EventType:      "default",
+		Visibility:     "default",
+	}
+}
+
+func TestNewWithoutRules(t *testing.T) {
+	f, err := New(Rules{})
+	if err != nil || f != nil {
+		t.Fatalf("New(Rules{}) = %v, %v, want nil, nil", f, err)
+	}
+	if !f.Match(event("Standup", "09:00", time.Hour)) {
+		t.Error("nil filter rejected an event")
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+		want  string
+	}{
+		{"title pattern", Rules{Include: []Rule{{Title: "(unclosed"}}}, "include rule 1: invalid title pattern"},
+		{"organizer pattern", Rules{Exclude: []Rule{{}, {Organizer: "[a-"}}}, "exclude rule 2: invalid organizer pattern"},
+		{"min duration", Rules{Include: []Rule{{MinDuration: "15 minutes"}}}, "invalid minDuration"},
+		{"max duration", Rules{Include: []Rule{{MaxDuration: "1d"}}}, "invalid maxDuration"},
+		{"starts after", Rules{Include: []Rule{{StartsAfter: "9am"}}}, "invalid startsAfter"},
+		{"starts before", Rules{Include: []Rule{{StartsBefore: "25:00"}}}, "invalid startsBefore"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.rules)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("New = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	standup := event("Standup", "09:00", 15*time.Minute)
+	lunch := event("Lunch", "12:00", time.Hour)
+	offsite := event("Offsite", "08:00", 9*time.Hour)
+	night := event("Deploy", "23:30", time.Hour)
+	early := event("Deploy", "05:00", time.Hour)
+	declined := event("Review", "14:00", time.Hour)
+	declined.ResponseStatus = "declined"
+	private := event("Doctor", "16:00", time.Hour)
+	private.Visibility = "private"
+	focus := event("Focus time", "10:00", 2*time.Hour)
+	focus.EventType = "focusTime"
+	family := event("Dinner", "19:00", 2*time.Hour)
+	family.Account, family.CalendarID = "home", "family@group.calendar.google.com"
+	external := event("Sync", "11:00", 30*time.Minute)
+	external.Organizer = "someone@partner.org"
+
+	tests := []struct {
+		name  string
+		rules Rules
+		event *models.Event
+		want  bool
+	}{
+		{"empty include rule matches everything", Rules{Include: []Rule{{}}}, standup, true},
+		{"title pattern", Rules{Include: []Rule{{Title: "(?i)^lunch$"}}}, lunch, true},
+		{"title pattern mismatch", Rules{Include: []Rule{{Title: "(?i)^lunch$"}}}, standup, false},
+		{"any include rule", Rules{Include: []Rule{{Title: "Lunch"}, {Title: "Standup"}}}, standup, true},
+		{"exclude wins over include", Rules{Include: []Rule{{Title: "Lunch"}}, Exclude: []Rule{{MinDuration: "30m"}}}, lunch, false},
+		{"exclude only", Rules{Exclude: []Rule{{Title: "Lunch"}}}, standup, true},
+		{"every condition of a rule", Rules{Exclude: []Rule{{Title: "Lunch", MaxDuration: "30m"}}}, lunch, true},
+
+		{"calendar ID", Rules{Include: []Rule{{Calendars: []string{"primary"}}}}, standup, true},
+		{"account and calendar ID", Rules{Include: []Rule{{Calendars: []string{"home/family@group.calendar.google.com"}}}}, family, true},
+		{"calendar of another account", Rules{Include: []Rule{{Calendars: []string{"home/primary"}}}}, standup, false},
+
+		{"organizer", Rules{Exclude: []Rule{{Organizer: `@partner\.org$`}}}, external, false},
+		{"organizer mismatch", Rules{Exclude: []Rule{{Organizer: `@partner\.org$`}}}, standup, true},
+		{"response status", Rules{Exclude: []Rule{{ResponseStatus: []string{"declined"}}}}, declined, false},
+		{"response status ignores case", Rules{Include: []Rule{{ResponseStatus: []string{"Accepted"}}}}, standup, true},
+		{"event type", Rules{Exclude: []Rule{{EventTypes: []string{"focusTime", "outOfOffice"}}}}, focus, false},
+		{"visibility", Rules{Exclude: []Rule{{Visibility: []string{"private", "confidential"}}}}, private, false},
+
+		{"shorter than min duration", Rules{Include: []Rule{{MinDuration: "30m"}}}, standup, false},
+		{"exactly min duration", Rules{Include: []Rule{{MinDuration: "15m"}}}, standup, true},
+		{"longer than max duration", Rules{Include: []Rule{{MaxDuration: "8h"}}}, offsite, false},
+		{"exactly max duration", Rules{Include: []Rule{{MaxDuration: "9h"}}}, offsite, true},
+
+		{"starts after, inclusive", Rules{Include: []Rule{{StartsAfter: "09:00"}}}, standup, true},
+		{"starts before, exclusive", Rules{Include: []Rule{{StartsBefore: "09:00"}}}, standup, false},
+		{"within working hours", Rules{Include: []Rule{{StartsAfter: "08:00", StartsBefore: "18:00"}}}, lunch, true},
+		{"outside working hours", Rules{Include: []Rule{{StartsAfter: "08:00", StartsBefore: "18:00"}}}, night, false},
+		{"night window before midnight", Rules{Include: []Rule{{StartsAfter: "22:00", StartsBefore: "06:00"}}}, night, true},
+		{"night window after midnight", Rules{Include: []Rule{{StartsAfter: "22:00", StartsBefore: "06:00"}}}, early, true},
+		{"outside night window", Rules{Include: []Rule{{StartsAfter: "22:00", StartsBefore: "06:00"}}}, lunch, false},
+		{"night window end is exclusive", Rules{Include: []Rule{{StartsAfter: "22:00", StartsBefore: "05:00"}}}, early, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(tt.rules)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if got := f.Match(tt.event); got != tt.want {
+				t.Errorf("Match(%q at %s) = %v, want %v", tt.event.Title, tt.event.StartTime.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
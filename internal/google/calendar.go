@@ -3,28 +3,40 @@ package google
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"syncal/internal/htmltext"
 	"syncal/internal/models"
 	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
 const (
 	credentialsFile = "credentials.json"
+
+	// maxReminders is how many reminders Google Calendar accepts on an event.
+	maxReminders = 5
 )
 
 // CalendarClient provides a client for interacting with the Google Calendar API.
 type CalendarClient struct {
 	service *calendar.Service
 	logger  *slog.Logger
+	account string
+
+	mu        sync.Mutex
+	calendars map[string]calendarInfo // By calendar ID
 }
 
 // NewClient creates a new Google Calendar client.
@@ -32,7 +44,8 @@ type CalendarClient struct {
 // It supports multiple accounts by looking for token files like token-user1.json, token-user2.json, etc.
 // The accountName is used to find the correct token file.
 func NewClient(ctx context.Context, logger *slog.Logger, clientID, clientSecret, accountName string) (*CalendarClient, error) {
-	config, err := getOAuthConfig(clientID, clientSecret)
+	// The scope only matters for the auth flow, refreshing a token keeps the scope it was granted.
+	config, err := getOAuthConfig(clientID, clientSecret, calendar.CalendarReadonlyScope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get OAuth config: %w", err)
 	}
@@ -49,33 +62,59 @@ func NewClient(ctx context.Context, logger *slog.Logger, clientID, clientSecret,
 		return nil, fmt.Errorf("failed to create calendar service: %w", err)
 	}
 
-	return &CalendarClient{service: service, logger: logger}, nil
+	return &CalendarClient{service: service, logger: logger, account: accountName, calendars: make(map[string]calendarInfo)}, nil
 }
 
-// GetUpcomingEvents fetches upcoming events from the specified calendar.
-func (c *CalendarClient) GetUpcomingEvents(calendarID string, days int) ([]*models.Event, error) {
-	c.logger.Debug("Fetching upcoming events", "calendarID", calendarID, "days", days)
-	now := time.Now().UTC()
-	tmax := now.Add(time.Duration(days) * 24 * time.Hour).Format(time.RFC3339)
-	tmin := now.Format(time.RFC3339)
+// Account returns the name of the account the client is authenticated as.
+func (c *CalendarClient) Account() string {
+	return c.account
+}
+
+// IsAuthError reports whether err was caused by a token that can no longer be refreshed,
+// meaning the account has to go through the 'auth' command again.
+func IsAuthError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr)
+}
 
-	events, err := c.service.Events.List(calendarID).
+// GetEvents fetches the events of the specified calendar that overlap the given time range.
+func (c *CalendarClient) GetEvents(ctx context.Context, calendarID string, start, end time.Time) ([]*models.Event, error) {
+	c.logger.Debug("Fetching events", "calendarID", calendarID, "start", start, "end", end)
+
+	// The API returns at most 250 events per page by default, so every page is collected.
+	var items []*calendar.Event
+	var defaultReminders []*calendar.EventReminder
+	err := c.service.Events.List(calendarID).
 		ShowDeleted(false).
 		SingleEvents(true).
-		TimeMin(tmin).
-		TimeMax(tmax).
+		TimeMin(start.UTC().Format(time.RFC3339)).
+		TimeMax(end.UTC().Format(time.RFC3339)).
 		OrderBy("startTime").
-		Do()
+		Pages(ctx, func(page *calendar.Events) error {
+			items = append(items, page.Items...)
+			defaultReminders = page.DefaultReminders
+			return nil
+		})
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve events: %w", err)
 	}
 
-	c.logger.Info("Successfully fetched events from Google Calendar", "count", len(events.Items), "calendarID", calendarID)
-	return c.toInternalEvents(events.Items, calendarID), nil
+	c.logger.Info("Successfully fetched events from Google Calendar", "count", len(items), "calendarID", calendarID)
+	internalEvents := c.toInternalEvents(items, defaultReminders, calendarID)
+	// Events without a color of their own are shown in the color of their calendar.
+	if cal := c.calendarInfo(ctx, calendarID); cal.color != "" {
+		for _, event := range internalEvents {
+			if event.Color == "" {
+				event.Color, event.ColorName = cal.color, cal.name
+			}
+		}
+	}
+	return internalEvents, nil
 }
 
 // toInternalEvents converts Google Calendar events to the internal Event model.
-func (c *CalendarClient) toInternalEvents(googleEvents []*calendar.Event, source string) []*models.Event {
+// Events using the default reminders of their calendar get defaultReminders.
+func (c *CalendarClient) toInternalEvents(googleEvents []*calendar.Event, defaultReminders []*calendar.EventReminder, source string) []*models.Event {
 	var internalEvents []*models.Event
 	for _, item := range googleEvents {
 		// Skip events without a start time (e.g., all-day events without a specific time)
@@ -85,10 +124,22 @@ func (c *CalendarClient) toInternalEvents(googleEvents []*calendar.Event, source
 
 		startTime, _ := time.Parse(time.RFC3339, item.Start.DateTime)
 		endTime, _ := time.Parse(time.RFC3339, item.End.DateTime)
+		updated, _ := time.Parse(time.RFC3339, item.Updated)
 
-		var attendees []string
+		var attendees []models.Attendee
+		responseStatus := ""
 		for _, a := range item.Attendees {
-			attendees = append(attendees, a.Email)
+			attendees = append(attendees, models.Attendee{
+				Email:          a.Email,
+				Name:           a.DisplayName,
+				ResponseStatus: a.ResponseStatus,
+				Self:           a.Self,
+				Optional:       a.Optional,
+				Resource:       a.Resource,
+			})
+			if a.Self {
+				responseStatus = a.ResponseStatus
+			}
 		}
 
 		event := &models.Event{
@@ -98,30 +149,232 @@ func (c *CalendarClient) toInternalEvents(googleEvents []*calendar.Event, source
 			StartTime:   startTime,
 			EndTime:     endTime,
 			Location:    item.Location,
-			Organizer:   item.Organizer.Email,
+			Organizer:   organizerEmail(item),
 			Attendees:   attendees,
 			UID:         item.ICalUID, // Use the iCalendar UID for syncing
-			Source:      fmt.Sprintf("google-%s", source),
+			Updated:     updated,
+			Source:      SourceName(source),
+			Account:     c.account,
+			CalendarID:  source,
+
+			ResponseStatus: responseStatus,
+			EventType:      item.EventType,
+			Visibility:     item.Visibility,
+			OrganizerName:  organizerName(item),
+			Status:         item.Status,
+			Transparency:   item.Transparency,
+			Link:           item.HtmlLink,
+			Conference:     conference(item),
+			Color:          colorName(item.ColorId),
+			ColorName:      eventColorNames[item.ColorId],
+			Attachments:    attachments(item),
+			Reminders:      reminders(item, defaultReminders),
+		}
+		if htmltext.IsHTML(item.Description) {
+			event.Description, event.DescriptionHTML = htmltext.ToText(item.Description), item.Description
+		}
+		// Events created by syncal remember the pipeline that wrote them.
+		if item.ExtendedProperties != nil {
+			event.Pipeline = item.ExtendedProperties.Private[PropSyncalPipeline]
 		}
 		internalEvents = append(internalEvents, event)
 	}
 	return internalEvents
 }
 
+// InsertEvent creates an event in the given calendar and returns it as stored by Google.
+// The event is imported with its iCalendar UID, so it stays linked to the original event.
+// It carries the syncal provenance marker. Attendees are not copied to avoid sending invitations.
+func (c *CalendarClient) InsertEvent(ctx context.Context, calendarID string, event *models.Event) (*models.Event, error) {
+	c.logger.Debug("Inserting event into Google Calendar", "calendarID", calendarID, "title", event.Title)
+
+	ge := toGoogleEvent(event)
+	ge.ICalUID = event.UID
+	ge.ExtendedProperties = provenance(event)
+	created, err := c.service.Events.Import(calendarID, ge).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert event: %w", err)
+	}
+
+	c.logger.Info("Successfully inserted event into Google Calendar", "title", event.Title, "calendarID", calendarID)
+	return c.toInternalEvent(created, calendarID), nil
+}
+
+// UpdateEvent updates the title, description, location and times of an existing event
+// and returns it as stored by Google.
+func (c *CalendarClient) UpdateEvent(ctx context.Context, calendarID, eventID string, event *models.Event) (*models.Event, error) {
+	c.logger.Debug("Updating event in Google Calendar", "calendarID", calendarID, "eventID", eventID)
+
+	updated, err := c.service.Events.Patch(calendarID, eventID, toGoogleEvent(event)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update event: %w", err)
+	}
+
+	c.logger.Info("Successfully updated event in Google Calendar", "title", event.Title, "calendarID", calendarID)
+	return c.toInternalEvent(updated, calendarID), nil
+}
+
+// DeleteEvent deletes an event from the given calendar.
+// Deleting an event that no longer exists is not an error.
+func (c *CalendarClient) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	c.logger.Debug("Deleting event from Google Calendar", "calendarID", calendarID, "eventID", eventID)
+
+	err := c.service.Events.Delete(calendarID, eventID).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone) {
+		c.logger.Debug("Event already absent from Google Calendar, nothing to delete.", "eventID", eventID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+
+	c.logger.Info("Successfully deleted event from Google Calendar", "eventID", eventID, "calendarID", calendarID)
+	return nil
+}
+
+// toInternalEvent converts a single Google Calendar event, returning nil if it can't be represented.
+func (c *CalendarClient) toInternalEvent(item *calendar.Event, calendarID string) *models.Event {
+	events := c.toInternalEvents([]*calendar.Event{item}, nil, calendarID)
+	if len(events) == 0 {
+		return nil
+	}
+	return events[0]
+}
+
+// toGoogleEvent converts the writable fields of an internal Event to a Google Calendar event.
+func toGoogleEvent(event *models.Event) *calendar.Event {
+	return &calendar.Event{
+		Summary:      event.Title,
+		Description:  description(event),
+		Location:     event.Location,
+		Start:        &calendar.EventDateTime{DateTime: event.StartTime.Format(time.RFC3339)},
+		End:          &calendar.EventDateTime{DateTime: event.EndTime.Format(time.RFC3339)},
+		Status:       googleStatus(event.Status),
+		Transparency: event.Transparency,
+		Visibility:   event.Visibility,
+		ColorId:      eventColorID(event.Color),
+		Reminders:    googleReminders(event.Reminders),
+	}
+}
+
+// googleReminders returns the reminders of an event as popup notifications, in place of the default
+// reminders of the calendar. Google allows at most maxReminders per event, the first ones are kept.
+func googleReminders(minutes []int) *calendar.EventReminders {
+	// UseDefault and an empty list of overrides are sent as well, so that an event without reminders gets none.
+	r := &calendar.EventReminders{ForceSendFields: []string{"UseDefault", "Overrides"}}
+	for _, m := range minutes[:min(len(minutes), maxReminders)] {
+		r.Overrides = append(r.Overrides, &calendar.EventReminder{Method: "popup", Minutes: int64(m)})
+	}
+	return r
+}
+
+// description returns the description of an event as written to Google, which displays HTML.
+func description(event *models.Event) string {
+	if event.DescriptionHTML != "" {
+		return event.DescriptionHTML
+	}
+	return event.Description
+}
+
+// googleStatus returns the Google Calendar status of an event with the given iCalendar status.
+func googleStatus(status string) string {
+	if status == "tentative" {
+		return "tentative"
+	}
+	return "confirmed"
+}
+
+// reminders returns the minutes before the start of an event at which Google shows a notification.
+// Email reminders are left out, they are sent by Google rather than shown by the calendar app.
+func reminders(item *calendar.Event, defaultReminders []*calendar.EventReminder) []int {
+	if item.Reminders == nil {
+		return nil
+	}
+	list := item.Reminders.Overrides
+	if item.Reminders.UseDefault {
+		list = defaultReminders
+	}
+	var minutes []int
+	for _, r := range list {
+		if r.Method == "popup" {
+			minutes = append(minutes, int(r.Minutes))
+		}
+	}
+	return minutes
+}
+
+// conference returns the video call of an event: its video entry point, or its Google Meet link.
+func conference(item *calendar.Event) models.Conference {
+	if data := item.ConferenceData; data != nil {
+		for _, ep := range data.EntryPoints {
+			if ep.EntryPointType != "video" {
+				continue
+			}
+			c := models.Conference{URL: ep.Uri}
+			if data.ConferenceSolution != nil {
+				c.Name = data.ConferenceSolution.Name
+			}
+			return c
+		}
+	}
+	if item.HangoutLink != "" {
+		return models.Conference{Name: "Google Meet", URL: item.HangoutLink}
+	}
+	return models.Conference{}
+}
+
+// attachments returns the files attached to an event, usually Google Drive files.
+func attachments(item *calendar.Event) []models.Attachment {
+	var list []models.Attachment
+	for _, a := range item.Attachments {
+		if a.FileUrl != "" {
+			list = append(list, models.Attachment{Title: a.Title, URL: a.FileUrl, MimeType: a.MimeType})
+		}
+	}
+	return list
+}
+
+// organizerEmail returns the organizer of an event, which Google omits for some events.
+func organizerEmail(item *calendar.Event) string {
+	if item.Organizer == nil {
+		return ""
+	}
+	return item.Organizer.Email
+}
+
+// organizerName returns the display name of the organizer of an event, if Google has one.
+func organizerName(item *calendar.Event) string {
+	if item.Organizer == nil {
+		return ""
+	}
+	return item.Organizer.DisplayName
+}
+
+// SourceName returns the models.Event Source value used for events of the given calendar.
+func SourceName(calendarID string) string {
+	return fmt.Sprintf("google-%s", calendarID)
+}
+
 // GetOAuthConfigForAuthFlow is used by the auth command to get the config for the web flow.
-func GetOAuthConfigForAuthFlow(clientID, clientSecret string) (*oauth2.Config, error) {
-	return getOAuthConfig(clientID, clientSecret)
+// If write is set, access to create and modify events is requested in addition to read access.
+func GetOAuthConfigForAuthFlow(clientID, clientSecret string, write bool) (*oauth2.Config, error) {
+	scope := calendar.CalendarReadonlyScope
+	if write {
+		scope = calendar.CalendarEventsScope
+	}
+	return getOAuthConfig(clientID, clientSecret, scope)
 }
 
-// getOAuthConfig reads credentials and returns an OAuth2 config.
+// getOAuthConfig reads credentials and returns an OAuth2 config for the given scope.
 // It prioritizes environment variables over a local credentials.json file.
-func getOAuthConfig(clientID, clientSecret string) (*oauth2.Config, error) {
+func getOAuthConfig(clientID, clientSecret, scope string) (*oauth2.Config, error) {
 	if clientID != "" && clientSecret != "" {
 		return &oauth2.Config{
 			ClientID:     clientID,
 			ClientSecret: clientSecret,
 			RedirectURL:  "urn:ietf:wg:oauth:2.0:oob",
-			Scopes:       []string{calendar.CalendarReadonlyScope},
+			Scopes:       []string{scope},
 			Endpoint:     google.Endpoint,
 		}, nil
 	}
@@ -134,7 +387,7 @@ func getOAuthConfig(clientID, clientSecret string) (*oauth2.Config, error) {
 		return nil, fmt.Errorf("unable to read client secret file: %w", err)
 	}
 
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	config, err := google.ConfigFromJSON(b, scope)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
 	}
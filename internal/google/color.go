@@ -0,0 +1,186 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// eventColors are the colors Google Calendar offers for events, by color ID, as returned by the Colors API.
+var eventColors = map[string]uint32{
+	"1":  0xa4bdfc, // Lavender
+	"2":  0x7ae7bf, // Sage
+	"3":  0xdbadff, // Grape
+	"4":  0xff887c, // Flamingo
+	"5":  0xfbd75b, // Banana
+	"6":  0xffb878, // Tangerine
+	"7":  0x46d6db, // Peacock
+	"8":  0xe1e1e1, // Graphite
+	"9":  0x5484ed, // Blueberry
+	"10": 0x51b749, // Basil
+	"11": 0xdc2127, // Tomato
+}
+
+// eventColorNames are the names Google Calendar shows for its event colors, by color ID.
+var eventColorNames = map[string]string{
+	"1":  "Lavender",
+	"2":  "Sage",
+	"3":  "Grape",
+	"4":  "Flamingo",
+	"5":  "Banana",
+	"6":  "Tangerine",
+	"7":  "Peacock",
+	"8":  "Graphite",
+	"9":  "Blueberry",
+	"10": "Basil",
+	"11": "Tomato",
+}
+
+// cssColors are the CSS color names Google colors are matched to, since the iCalendar COLOR
+// property (RFC 7986) only takes names.
+var cssColors = []struct {
+	name string
+	rgb  uint32
+}{
+	{"black", 0x000000},
+	{"gray", 0x808080},
+	{"silver", 0xc0c0c0},
+	{"gainsboro", 0xdcdcdc},
+	{"brown", 0xa52a2a},
+	{"sienna", 0xa0522d},
+	{"rosybrown", 0xbc8f8f},
+	{"red", 0xff0000},
+	{"crimson", 0xdc143c},
+	{"tomato", 0xff6347},
+	{"salmon", 0xfa8072},
+	{"coral", 0xff7f50},
+	{"lightsalmon", 0xffa07a},
+	{"darkorange", 0xff8c00},
+	{"orange", 0xffa500},
+	{"gold", 0xffd700},
+	{"khaki", 0xf0e68c},
+	{"yellow", 0xffff00},
+	{"yellowgreen", 0x9acd32},
+	{"limegreen", 0x32cd32},
+	{"green", 0x008000},
+	{"forestgreen", 0x228b22},
+	{"seagreen", 0x2e8b57},
+	{"mediumseagreen", 0x3cb371},
+	{"mediumaquamarine", 0x66cdaa},
+	{"turquoise", 0x40e0d0},
+	{"teal", 0x008080},
+	{"powderblue", 0xb0e0e6},
+	{"lightblue", 0xadd8e6},
+	{"skyblue", 0x87ceeb},
+	{"deepskyblue", 0x00bfff},
+	{"cornflowerblue", 0x6495ed},
+	{"dodgerblue", 0x1e90ff},
+	{"royalblue", 0x4169e1},
+	{"blue", 0x0000ff},
+	{"navy", 0x000080},
+	{"slateblue", 0x6a5acd},
+	{"mediumpurple", 0x9370db},
+	{"plum", 0xdda0dd},
+	{"purple", 0x800080},
+	{"orchid", 0xda70d6},
+	{"violet", 0xee82ee},
+	{"hotpink", 0xff69b4},
+	{"pink", 0xffc0cb},
+}
+
+// colorName returns the CSS color name closest to a Google color, given as an event color ID or as
+// a hex code like "#9fc6e7". It returns "" if the color isn't understood.
+func colorName(color string) string {
+	rgb, ok := eventColors[color]
+	if !ok {
+		if len(color) != len("#rrggbb") || color[0] != '#' {
+			return ""
+		}
+		v, err := strconv.ParseUint(color[1:], 16, 32)
+		if err != nil {
+			return ""
+		}
+		rgb = uint32(v)
+	}
+	best, bestDist := "", -1
+	for _, c := range cssColors {
+		if d := distance(rgb, c.rgb); bestDist < 0 || d < bestDist {
+			best, bestDist = c.name, d
+		}
+	}
+	return best
+}
+
+// eventColorID returns the ID of the Google event color closest to a CSS color name, or "" if the
+// name isn't one of cssColors. Names of event colors given by colorName map back to the same color.
+func eventColorID(name string) string {
+	for id := range eventColors {
+		if colorName(id) == name {
+			return id
+		}
+	}
+	rgb, ok := uint32(0), false
+	for _, c := range cssColors {
+		if strings.EqualFold(c.name, name) {
+			rgb, ok = c.rgb, true
+		}
+	}
+	if !ok {
+		return ""
+	}
+	best, bestDist := "", -1
+	for id := 1; id <= len(eventColors); id++ {
+		if d := distance(rgb, eventColors[strconv.Itoa(id)]); bestDist < 0 || d < bestDist {
+			best, bestDist = strconv.Itoa(id), d
+		}
+	}
+	return best
+}
+
+// distance returns the squared distance between two RGB colors.
+func distance(a, b uint32) int {
+	d := 0
+	for shift := 0; shift <= 16; shift += 8 {
+		diff := int(a>>shift&0xff) - int(b>>shift&0xff)
+		d += diff * diff
+	}
+	return d
+}
+
+// calendarInfo is what the user's calendar list says about a calendar.
+type calendarInfo struct {
+	color string // CSS color name
+	name  string // The name the user gave the calendar, or else its title
+}
+
+// calendarInfo returns the color and name of a calendar, from its entry in the user's calendar list.
+// Calendars are looked up once per client. Tokens authorized for writing events only can't read the
+// calendar list, and calendars get no color or name then.
+func (c *CalendarClient) calendarInfo(ctx context.Context, calendarID string) calendarInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if info, ok := c.calendars[calendarID]; ok {
+		return info
+	}
+	entry, err := c.service.CalendarList.Get(calendarID).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		// The token can't read the calendar list, or the calendar isn't in it: remember it has no color.
+		c.logger.Debug("Could not read calendar color", "calendarID", calendarID, "error", err)
+		c.calendars[calendarID] = calendarInfo{}
+		return calendarInfo{}
+	}
+	if err != nil {
+		c.logger.Debug("Could not read calendar color", "calendarID", calendarID, "error", err)
+		return calendarInfo{}
+	}
+	info := calendarInfo{color: colorName(entry.BackgroundColor), name: entry.SummaryOverride}
+	if info.name == "" {
+		info.name = entry.Summary
+	}
+	c.calendars[calendarID] = info
+	return info
+}
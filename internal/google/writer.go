@@ -0,0 +1,138 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syncal/internal/models"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	// PropSyncalSource is the private extended property that marks events created by syncal.
+	// Its value is the source the event was synced from (e.g., "icloud").
+	PropSyncalSource = "syncalSource"
+	// PropSyncalPipeline records the syncal pipeline that created the event.
+	PropSyncalPipeline = "syncalPipeline"
+)
+
+// ErrForeignObject is returned when an operation would modify an event that syncal did not create.
+var ErrForeignObject = errors.New("calendar event is not managed by syncal")
+
+// CalendarWriter writes events to a single Google calendar, identifying them by their iCalendar UID.
+type CalendarWriter struct {
+	client     *CalendarClient
+	calendarID string
+
+	// foreignCollisions counts UID collisions with events that syncal does not own.
+	foreignCollisions atomic.Int64
+}
+
+// Writer returns a CalendarWriter for the given calendar of the client's account.
+// The account must have been authorized with the 'auth --write' command.
+func (c *CalendarClient) Writer(calendarID string) *CalendarWriter {
+	return &CalendarWriter{client: c, calendarID: calendarID}
+}
+
+// SyncEvent creates or updates the event with the same UID in the calendar and returns the ETag of the written event.
+// The owned flag tells whether the sync state already maps this event to the target event.
+// If it doesn't, an existing event with the same UID is only overwritten when it carries
+// the syncal provenance marker; otherwise ErrForeignObject is returned.
+func (w *CalendarWriter) SyncEvent(ctx context.Context, event *models.Event, owned bool) (string, error) {
+	c := w.client
+	c.logger.Debug("Syncing event to Google Calendar", "eventTitle", event.Title, "uid", event.UID)
+
+	existing, err := w.findByUID(ctx, event.UID)
+	if err != nil {
+		return "", err
+	}
+
+	var written *calendar.Event
+	if existing == nil {
+		ge := toGoogleEvent(event)
+		ge.ICalUID = event.UID
+		ge.ExtendedProperties = provenance(event)
+		written, err = c.service.Events.Import(w.calendarID, ge).Context(ctx).Do()
+	} else {
+		if !owned && !isManaged(existing) {
+			w.reportForeignCollision(event.UID, "overwrite")
+			return "", fmt.Errorf("refusing to overwrite event %s: %w", event.UID, ErrForeignObject)
+		}
+		written, err = c.service.Events.Patch(w.calendarID, existing.Id, toGoogleEvent(event)).Context(ctx).Do()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write event: %w", err)
+	}
+
+	c.logger.Info("Successfully synced event to Google Calendar", "eventTitle", event.Title, "calendarID", w.calendarID)
+	return written.Etag, nil
+}
+
+// DeleteEvent removes the event with the given UID from the calendar.
+// Like SyncEvent, it refuses to delete events that syncal does not own.
+// Deleting an event that no longer exists is not an error.
+func (w *CalendarWriter) DeleteEvent(ctx context.Context, uid string, owned bool) error {
+	existing, err := w.findByUID(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		w.client.logger.Debug("Event already absent from Google Calendar, nothing to delete.", "uid", uid)
+		return nil
+	}
+	if !owned && !isManaged(existing) {
+		w.reportForeignCollision(uid, "delete")
+		return fmt.Errorf("refusing to delete event %s: %w", uid, ErrForeignObject)
+	}
+	return w.client.DeleteEvent(ctx, w.calendarID, existing.Id)
+}
+
+// ForeignCollisions returns how many UID collisions with foreign events were detected so far.
+func (w *CalendarWriter) ForeignCollisions() int64 {
+	return w.foreignCollisions.Load()
+}
+
+// CalendarKey returns the "account/calendarID" key of the calendar the writer writes to.
+func (w *CalendarWriter) CalendarKey() string {
+	return w.client.Account() + "/" + w.calendarID
+}
+
+// findByUID returns the event with the given iCalendar UID, or nil if the calendar has none.
+func (w *CalendarWriter) findByUID(ctx context.Context, uid string) (*calendar.Event, error) {
+	events, err := w.client.service.Events.List(w.calendarID).
+		ICalUID(uid).
+		ShowDeleted(false).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up event %s: %w", uid, err)
+	}
+	if len(events.Items) == 0 {
+		return nil, nil
+	}
+	return events.Items[0], nil
+}
+
+// reportForeignCollision records and logs a UID collision with an event syncal doesn't own.
+func (w *CalendarWriter) reportForeignCollision(uid, operation string) {
+	total := w.foreignCollisions.Add(1)
+	w.client.logger.Warn("UID collision with an event not managed by syncal, leaving it untouched",
+		"uid", uid, "operation", operation, "calendarID", w.calendarID, "totalCollisions", total)
+}
+
+// provenance returns the extended properties that mark an event as created by syncal.
+func provenance(event *models.Event) *calendar.EventExtendedProperties {
+	return &calendar.EventExtendedProperties{
+		Private: map[string]string{
+			PropSyncalSource:   event.Source,
+			PropSyncalPipeline: event.Pipeline,
+		},
+	}
+}
+
+// isManaged reports whether a Google event carries the syncal provenance marker.
+func isManaged(item *calendar.Event) bool {
+	return item.ExtendedProperties != nil && item.ExtendedProperties.Private[PropSyncalSource] != ""
+}
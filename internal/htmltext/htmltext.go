@@ -0,0 +1,140 @@
+// Package htmltext converts the HTML of event descriptions to plain text.
+package htmltext
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// htmlTag matches the tags that tell an HTML description from plain text that happens to contain "<".
+// Attributes need a value, so that text like "a<b and c>d" isn't taken for a tag.
+var htmlTag = regexp.MustCompile(`(?is)<(/?(a|b|i|u|p|br|div|span|strong|em|ul|ol|li|h[1-6]|table|tr|td|html|body)(\s+[a-z-]+=("[^"]*"|'[^']*'|[^\s>]+))*\s*/?|!--.*?--)>`)
+
+// blocks are the elements that start on a line of their own.
+var blocks = map[string]bool{
+	"p": true, "div": true, "ul": true, "ol": true, "li": true, "table": true, "tr": true, "blockquote": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true, "hr": true,
+}
+
+// IsHTML reports whether a description is HTML rather than plain text.
+func IsHTML(s string) bool {
+	return htmlTag.MatchString(s)
+}
+
+// ToText converts HTML to readable plain text. Line breaks and blocks become new lines, list items
+// start with "- ", and links keep their target in parentheses after their text.
+func ToText(s string) string {
+	var b strings.Builder
+	var href string  // Target of the link being read
+	var linkText int // Length of b when the link started
+	var skip int     // Depth inside elements whose text is not shown
+	newline := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+		switch tt {
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(collapseSpaces(token.Data))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch name := token.Data; {
+			case name == "script" || name == "style":
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case name == "br":
+				b.WriteString("\n")
+			case name == "a":
+				href, linkText = attr(token, "href"), b.Len()
+			case blocks[name]:
+				newline()
+				if name == "li" {
+					b.WriteString("- ")
+				}
+			}
+		case html.EndTagToken:
+			switch name := token.Data; {
+			case name == "script" || name == "style":
+				if skip > 0 {
+					skip--
+				}
+			case name == "a":
+				if href != "" && !strings.HasPrefix(href, "#") {
+					text := strings.TrimSpace(b.String()[linkText:])
+					switch {
+					case text == "":
+						b.WriteString(href)
+					case text != href && text != strings.TrimPrefix(href, "mailto:"):
+						b.WriteString(" (" + href + ")")
+					}
+				}
+				href = ""
+			case blocks[name]:
+				newline()
+			}
+		}
+	}
+	return tidy(b.String())
+}
+
+// attr returns the value of an attribute of a tag.
+func attr(token html.Token, name string) string {
+	for _, a := range token.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// spaces matches runs of whitespace other than line breaks, which descriptions keep even when
+// they are HTML.
+var spaces = regexp.MustCompile(`[ \t\r\f\v\x{a0}]+`)
+
+// collapseSpaces turns runs of spaces into a single space, as browsers do.
+func collapseSpaces(s string) string {
+	return spaces.ReplaceAllString(s, " ")
+}
+
+// blankLines matches more than one empty line.
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// tidy trims the lines of the text and drops repeated empty lines.
+func tidy(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// Truncate shortens text to at most max characters, ending with "…". It cuts at the last line break
+// or space in the final fifth of the limit, so that words aren't split; text without one there, like a
+// long URL, is cut mid-word. Multi-byte characters are never split.
+func Truncate(s string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)[:max-1]
+	cut := len(runes)
+	for i := len(runes) - 1; i >= len(runes)*4/5; i-- {
+		if runes[i] == '\n' || runes[i] == ' ' {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRight(string(runes[:cut]), " \n") + "…"
+}
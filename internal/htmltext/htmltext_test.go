@@ -0,0 +1,92 @@
+package htmltext
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestIsHTML(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"Agenda:\n- Updates", false},
+		{"if a<b and c>d", false},
+		{"<3 the team", false},
+		{"Line one<br>Line two", true},
+		{"<BR/>", true},
+		{`<a href="https://example.com">Doc</a>`, true},
+		{"<p>Hello</p>", true},
+		{"Notes <!-- hidden -->", true},
+	}
+	for _, tt := range tests {
+		if got := IsHTML(tt.s); got != tt.want {
+			t.Errorf("IsHTML(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"line breaks", "Line one<br>Line two<br/>Line three", "Line one\nLine two\nLine three"},
+		{"entities", "Tom &amp; Jerry&nbsp;&lt;3 &quot;caf&eacute;&quot;", `Tom & Jerry <3 "café"`},
+		{"bold and spaces", "<b>Bold</b>   and\t<i>italic</i>", "Bold and italic"},
+		{"paragraphs", "<p>First</p><p>Second</p>", "First\nSecond"},
+		{"list", "Agenda:<ul><li>Updates</li><li>Roadmap</li></ul>Thanks", "Agenda:\n- Updates\n- Roadmap\nThanks"},
+		{"link", `See <a href="https://example.com/doc">the doc</a>.`, "See the doc (https://example.com/doc)."},
+		{"link text is the target", `<a href="https://example.com">https://example.com</a>`, "https://example.com"},
+		{"mail link", `<a href="mailto:bob@example.com">bob@example.com</a>`, "bob@example.com"},
+		{"link without text", `<a href="https://example.com"></a>`, "https://example.com"},
+		{"anchor link", `<a href="#top">Top</a>`, "Top"},
+		{"script and style", "<style>p { color: red }</style>Text<script>alert(1)</script>", "Text"},
+		{"blank lines", "<p>One</p><br><br><br><br><p>Two</p>", "One\n\nTwo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToText(tt.html); got != tt.want {
+				t.Errorf("ToText(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		max  int
+		want string
+	}{
+		{"short enough", "Standup notes", 20, "Standup notes"},
+		{"exactly the limit", "Standup", 7, "Standup"},
+		{"no limit", "Standup notes", 0, "Standup notes"},
+		{"at a space", "the quick brown fox jumps", 18, "the quick brown…"},
+		{"at a line break", "first line\nsecond line", 12, "first line…"},
+		{"no space near the limit", "see https://example.com/a/very/long/path", 30, "see https://example.com/a/ver…"},
+		{"no space at all", "abcdefghijklmnopqrstuvwxyz", 10, "abcdefghi…"},
+		{"multibyte characters", "ééééééééééééééé", 10, "ééééééééé…"},
+		{"multibyte words", "こんにちは 世界 さようなら 世界", 12, "こんにちは 世界…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Truncate(tt.s, tt.max)
+			if got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Truncate(%q, %d) split a character: %q", tt.s, tt.max, got)
+			}
+			if tt.max > 0 && utf8.RuneCountInString(got) > tt.max {
+				t.Errorf("Truncate(%q, %d) = %q, longer than the limit", tt.s, tt.max, got)
+			}
+			if got != tt.s && !strings.HasSuffix(got, "…") {
+				t.Errorf("Truncate(%q, %d) = %q, doesn't end with an ellipsis", tt.s, tt.max, got)
+			}
+		})
+	}
+}
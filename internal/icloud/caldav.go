@@ -1,12 +1,18 @@
 package icloud
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syncal/internal/ics"
 	"syncal/internal/models"
 	"time"
 
@@ -18,8 +24,19 @@ import (
 
 const (
 	iCloudCalDAVEndpoint = "https://caldav.icloud.com/"
+
+	// PropSyncalSource is the provenance marker written on every VEVENT created by syncal.
+	PropSyncalSource = ics.PropSyncalSource
+	// PropSyncalPipeline records the syncal pipeline that wrote the VEVENT.
+	PropSyncalPipeline = ics.PropSyncalPipeline
 )
 
+// ErrForeignObject is returned when an operation would modify a calendar object that syncal did not create.
+var ErrForeignObject = errors.New("calendar object is not managed by syncal")
+
+// ErrObjectChanged is returned when a calendar object changed on the server since its ETag was read.
+var ErrObjectChanged = errors.New("calendar object changed on the CalDAV server")
+
 // customTransport handles adding Basic Auth and custom headers to requests.
 type customTransport struct {
 	Username  string
@@ -34,17 +51,38 @@ func (t *customTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return t.Transport.RoundTrip(req)
 }
 
-// CalDAVClient is a client for interacting with a CalDAV server (iCloud).
+// CalDAVClient is a client for interacting with a CalDAV server (iCloud by default).
 type CalDAVClient struct {
 	caldavClient *caldav.Client
 	webdavClient *webdav.Client
+	httpClient   *http.Client
 	logger       *slog.Logger
-	calendarURL  string
+	endpoint     *url.URL
+	calendarPath string // Path of the calendar collection on the server
+	calendarName string
 	username     string
+
+	// foreignCollisions counts UID collisions with objects that syncal does not own.
+	foreignCollisions atomic.Int64
+
+	// Incremental sync state of FetchEvents.
+	syncMu sync.Mutex
+	sync   *collectionState
 }
 
 // NewClient creates and initializes a new CalDAVClient for iCloud.
 func NewClient(logger *slog.Logger, username, password, calendarName string) (*CalDAVClient, error) {
+	return NewServerClient(logger, iCloudCalDAVEndpoint, username, password, calendarName)
+}
+
+// NewServerClient creates and initializes a new CalDAVClient for the named calendar on any CalDAV
+// server, such as Nextcloud or Baikal. The endpoint is the URL calendars are discovered from.
+func NewServerClient(logger *slog.Logger, endpoint, username, password, calendarName string) (*CalDAVClient, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid caldav endpoint: %w", err)
+	}
+
 	transport := &customTransport{
 		Username:  username,
 		Password:  password,
@@ -52,12 +90,12 @@ func NewClient(logger *slog.Logger, username, password, calendarName string) (*C
 	}
 	httpClient := &http.Client{Transport: transport}
 
-	caldavClient, err := caldav.NewClient(httpClient, iCloudCalDAVEndpoint)
+	caldavClient, err := caldav.NewClient(httpClient, endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create caldav client: %w", err)
 	}
 
-	webdavClient, err := webdav.NewClient(httpClient, iCloudCalDAVEndpoint)
+	webdavClient, err := webdav.NewClient(httpClient, endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create webdav client: %w", err)
 	}
@@ -65,77 +103,426 @@ func NewClient(logger *slog.Logger, username, password, calendarName string) (*C
 	c := &CalDAVClient{
 		caldavClient: caldavClient,
 		webdavClient: webdavClient,
+		httpClient:   httpClient,
 		logger:       logger,
+		endpoint:     endpointURL,
+		calendarName: calendarName,
 		username:     username,
 	}
 
-	logger.Info("Finding iCloud calendar", "calendarName", calendarName)
-	calendarURL, err := c.findCalendar(context.Background(), calendarName)
+	logger.Info("Finding CalDAV calendar", "server", endpointURL.Host, "calendarName", calendarName)
+	calendarPath, err := c.findCalendar(context.Background(), calendarName)
 	if err != nil {
 		return nil, fmt.Errorf("could not find calendar '%s': %w", calendarName, err)
 	}
-	c.calendarURL = calendarURL
-	logger.Info("Successfully found iCloud calendar", "url", calendarURL)
+	c.calendarPath = calendarPath
+	logger.Info("Successfully found CalDAV calendar", "url", c.objectURL(calendarPath))
 
 	return c, nil
 }
 
-// SyncEvent creates or updates an event in the iCloud calendar.
-func (c *CalDAVClient) SyncEvent(ctx context.Context, event *models.Event) error {
-	c.logger.Debug("Syncing event to iCloud", "eventTitle", event.Title, "uid", event.UID)
+// RemoteEvent is an event read from the iCloud calendar.
+type RemoteEvent struct {
+	Path    string // Path of the calendar object on the CalDAV server
+	ETag    string
+	Managed bool // Whether the object carries the syncal provenance marker
+	Event   *models.Event
+}
 
-	vevent := c.toICal(event)
-	cal := ical.NewCalendar()
-	cal.Props.SetText(ical.PropVersion, "2.0")
-	cal.Props.SetText(ical.PropProductID, "-//syncal//EN")
-	cal.Children = append(cal.Children, vevent)
+// objectInfo describes an existing calendar object.
+type objectInfo struct {
+	exists  bool
+	managed bool
+	etag    string
+}
+
+// precondition is the conditional header a write is sent with, so that an object created or changed
+// after its ownership was checked isn't overwritten. The zero value writes unconditionally.
+type precondition struct {
+	header string // "If-Match" or "If-None-Match"
+	value  string
+}
 
-	// The event path must be relative to the endpoint for the webdav client.
-	eventPath := path.Join(strings.TrimPrefix(c.calendarURL, iCloudCalDAVEndpoint), fmt.Sprintf("%s.ics", event.UID))
+// SyncEvent creates or updates an event in the iCloud calendar and returns the ETag of the written object.
+// The owned flag tells whether the sync state already maps this event to the target object.
+// If it doesn't, an existing object with the same UID is only overwritten when it carries
+// the syncal provenance marker; otherwise ErrForeignObject is returned.
+func (c *CalDAVClient) SyncEvent(ctx context.Context, event *models.Event, owned bool) (string, error) {
+	c.logger.Debug("Syncing event to iCloud", "eventTitle", event.Title, "uid", event.UID)
 
-	writer, err := c.webdavClient.Create(ctx, eventPath)
+	eventPath := c.eventPath(event.UID)
+	cond, err := c.checkOwnership(ctx, eventPath, event.UID, owned)
 	if err != nil {
-		return fmt.Errorf("failed to create event on CalDAV server: %w", err)
+		return "", err
 	}
-	defer writer.Close()
 
-	if err := ical.NewEncoder(writer).Encode(cal); err != nil {
-		return fmt.Errorf("failed to encode event to iCal format: %w", err)
+	etag, err := c.put(ctx, eventPath, ics.EncodeEvent(event, true), cond)
+	if err != nil {
+		return "", err
 	}
 
 	c.logger.Info("Successfully synced event to iCloud", "eventTitle", event.Title)
+	return etag, nil
+}
+
+// UpdateObject overwrites the calendar object at objectPath with the given event and returns its new ETag.
+// It is used for events that were created in iCloud rather than by syncal, so the provenance marker is not added.
+// Callers must only pass objects that the sync state maps to a source event.
+// The object is only written if its ETag is still etag, otherwise ErrObjectChanged is returned.
+func (c *CalDAVClient) UpdateObject(ctx context.Context, objectPath, etag string, event *models.Event) (string, error) {
+	c.logger.Debug("Updating iCloud object", "eventTitle", event.Title, "path", objectPath)
+
+	var cond precondition
+	if etag != "" {
+		cond = precondition{header: "If-Match", value: `"` + etag + `"`}
+	}
+	etag, err := c.put(ctx, objectPath, ics.EncodeEvent(event, false), cond)
+	if err != nil {
+		return "", err
+	}
+
+	c.logger.Info("Successfully updated event in iCloud", "eventTitle", event.Title)
+	return etag, nil
+}
+
+// DeleteEvent removes the event with the given UID from the iCloud calendar.
+// Like SyncEvent, it refuses to delete objects that syncal does not own.
+// Deleting an event that no longer exists is not an error.
+func (c *CalDAVClient) DeleteEvent(ctx context.Context, uid string, owned bool) error {
+	c.logger.Debug("Deleting event from iCloud", "uid", uid)
+
+	eventPath := c.eventPath(uid)
+	info, err := c.inspectObject(ctx, eventPath)
+	if err != nil {
+		return err
+	}
+	if !info.exists {
+		c.logger.Debug("Event already absent from iCloud, nothing to delete.", "uid", uid)
+		return nil
+	}
+	if !owned && !info.managed {
+		c.reportForeignCollision(uid, "delete")
+		return fmt.Errorf("refusing to delete event %s: %w", uid, ErrForeignObject)
+	}
+
+	return c.DeleteObject(ctx, eventPath)
+}
+
+// DeleteObject removes the calendar object at objectPath.
+// Callers must only pass objects that the sync state maps to a source event.
+func (c *CalDAVClient) DeleteObject(ctx context.Context, objectPath string) error {
+	if err := c.webdavClient.RemoveAll(ctx, objectPath); err != nil {
+		return fmt.Errorf("failed to delete event on CalDAV server: %w", err)
+	}
+
+	c.logger.Info("Successfully deleted event from iCloud", "path", objectPath)
 	return nil
 }
 
-// toICal converts an internal Event model to an ical.Component (VEvent).
-func (c *CalDAVClient) toICal(event *models.Event) *ical.Component {
-	ve := ical.NewComponent(ical.CompEvent)
-	ve.Props.SetText(ical.PropUID, event.UID)
-	ve.Props.SetText(ical.PropSummary, event.Title)
-	ve.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
-	ve.Props.SetDateTime(ical.PropDateTimeStart, event.StartTime)
-	ve.Props.SetDateTime(ical.PropDateTimeEnd, event.EndTime)
+// ListEvents returns the events of the iCloud calendar that overlap the given time range.
+// Recurring and all-day events are not supported and are skipped.
+func (c *CalDAVClient) ListEvents(ctx context.Context, start, end time.Time) ([]RemoteEvent, error) {
+	c.logger.Debug("Listing iCloud events", "start", start, "end", end)
 
-	if event.Description != "" {
-		ve.Props.SetText(ical.PropDescription, event.Description)
+	query := &caldav.CalendarQuery{
+		CompRequest: caldav.CalendarCompRequest{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CalendarCompRequest{{Name: ical.CompEvent, AllProps: true}},
+		},
+		CompFilter: caldav.CompFilter{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CompFilter{{Name: ical.CompEvent, Start: start, End: end}},
+		},
+	}
+	objects, err := c.caldavClient.QueryCalendar(ctx, c.calendarPath, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calendar: %w", err)
 	}
-	if event.Location != "" {
-		ve.Props.SetText(ical.PropLocation, event.Location)
+
+	var events []RemoteEvent
+	for _, obj := range objects {
+		if obj.Data == nil {
+			continue
+		}
+		for _, child := range obj.Data.Children {
+			if child.Name != ical.CompEvent {
+				continue
+			}
+			event, ok := c.fromICal(child)
+			if !ok {
+				continue
+			}
+			if event.Updated.IsZero() {
+				event.Updated = obj.ModTime
+			}
+			event.Account, event.CalendarID = c.username, c.calendarName
+			events = append(events, RemoteEvent{
+				Path:    obj.Path,
+				ETag:    obj.ETag,
+				Managed: child.Props.Get(PropSyncalSource) != nil,
+				Event:   event,
+			})
+			break
+		}
 	}
-	if event.Organizer != "" {
-		p := ical.NewProp(ical.PropOrganizer)
-		p.SetText(fmt.Sprintf("mailto:%s", event.Organizer))
-		ve.Props.Add(p)
+
+	c.logger.Info("Successfully fetched events from iCloud", "count", len(events))
+	return events, nil
+}
+
+// ManagedEvent is an object in the iCloud calendar that carries the syncal provenance marker.
+type ManagedEvent struct {
+	UID      string
+	Title    string
+	Pipeline string // Empty for events written before pipelines were recorded
+}
+
+// ListManagedEvents returns every event in the iCloud calendar that was created by syncal.
+func (c *CalDAVClient) ListManagedEvents(ctx context.Context) ([]ManagedEvent, error) {
+	query := &caldav.CalendarQuery{
+		CompRequest: caldav.CalendarCompRequest{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CalendarCompRequest{{Name: ical.CompEvent, AllProps: true}},
+		},
+		CompFilter: caldav.CompFilter{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CompFilter{{Name: ical.CompEvent}},
+		},
 	}
-	for _, attendee := range event.Attendees {
-		p := ical.NewProp(ical.PropAttendee)
-		p.SetText(fmt.Sprintf("mailto:%s", attendee))
-		ve.Props.Add(p)
+	objects, err := c.caldavClient.QueryCalendar(ctx, c.calendarPath, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calendar: %w", err)
+	}
+
+	var managed []ManagedEvent
+	for _, obj := range objects {
+		if obj.Data == nil {
+			continue
+		}
+		for _, child := range obj.Data.Children {
+			if child.Name != ical.CompEvent || child.Props.Get(PropSyncalSource) == nil {
+				continue
+			}
+			uid, _ := child.Props.Text(ical.PropUID)
+			title, _ := child.Props.Text(ical.PropSummary)
+			pipeline, _ := child.Props.Text(PropSyncalPipeline)
+			managed = append(managed, ManagedEvent{UID: uid, Title: title, Pipeline: pipeline})
+			break
+		}
+	}
+	return managed, nil
+}
+
+// CalendarKey returns the "account/calendar" key that identifies the iCloud calendar in sync runs.
+func (c *CalDAVClient) CalendarKey() string {
+	return c.username + "/" + c.calendarName
+}
+
+// IsICloud reports whether the client talks to iCloud rather than another CalDAV server.
+func (c *CalDAVClient) IsICloud() bool {
+	return c.endpoint.String() == iCloudCalDAVEndpoint
+}
+
+// ForeignCollisions returns how many UID collisions with foreign objects were detected so far.
+func (c *CalDAVClient) ForeignCollisions() int64 {
+	return c.foreignCollisions.Load()
+}
+
+// objectURL returns the full URL of a path on the CalDAV server.
+func (c *CalDAVClient) objectURL(objectPath string) string {
+	u := *c.endpoint
+	u.Path = "/" + strings.TrimPrefix(objectPath, "/")
+	return u.String()
+}
+
+// eventPath returns the path of the object syncal writes for the given UID.
+func (c *CalDAVClient) eventPath(uid string) string {
+	return path.Join(c.calendarPath, fmt.Sprintf("%s.ics", uid))
+}
+
+// put writes a single VEVENT to objectPath with the given precondition and returns the ETag of the stored object.
+func (c *CalDAVClient) put(ctx context.Context, objectPath string, vevent *ical.Component, cond precondition) (string, error) {
+	cal := ics.NewCalendar()
+	cal.Children = append(cal.Children, vevent)
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return "", fmt.Errorf("failed to encode event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(objectPath), &buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", ical.MIMEType)
+	if cond.header != "" {
+		req.Header.Set(cond.header, cond.value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to write event on CalDAV server: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		// Someone else wrote the object since it was checked, the next cycle checks it again.
+		return "", fmt.Errorf("event at %s: %w", objectPath, ErrObjectChanged)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("unexpected status writing event: %s", resp.Status)
+	}
+	if etag := strings.Trim(resp.Header.Get("ETag"), `"`); etag != "" {
+		return etag, nil
+	}
+
+	// Not every server returns the ETag on PUT, so read it back.
+	info, err := c.inspectObject(ctx, objectPath)
+	if err != nil {
+		return "", err
+	}
+	return info.etag, nil
+}
+
+// checkOwnership makes sure that writing to eventPath won't clobber an object created by someone else,
+// and that no other object of the calendar has the same UID. It returns the precondition the write
+// must be sent with, so that an object created or changed since the check is left alone.
+func (c *CalDAVClient) checkOwnership(ctx context.Context, eventPath, uid string, owned bool) (precondition, error) {
+	if owned {
+		return precondition{}, nil
+	}
+	info, err := c.inspectObject(ctx, eventPath)
+	if err != nil {
+		return precondition{}, err
+	}
+	if info.exists && !info.managed {
+		c.reportForeignCollision(uid, "overwrite")
+		return precondition{}, fmt.Errorf("refusing to overwrite event %s: %w", uid, ErrForeignObject)
+	}
+
+	// Clients other than syncal name objects as they like, so the UID can be taken at another path.
+	others, err := c.findByUID(ctx, uid)
+	if err != nil {
+		return precondition{}, err
+	}
+	for _, other := range others {
+		if path.Clean(other.Path) != path.Clean(eventPath) && !other.Managed {
+			c.reportForeignCollision(uid, "overwrite")
+			return precondition{}, fmt.Errorf("refusing to write event %s, another object has its UID: %w", uid, ErrForeignObject)
+		}
+	}
+
+	switch {
+	case !info.exists:
+		return precondition{header: "If-None-Match", value: "*"}, nil
+	case info.etag != "":
+		return precondition{header: "If-Match", value: `"` + info.etag + `"`}, nil
+	default:
+		return precondition{}, nil
+	}
+}
+
+// findByUID returns the objects of the calendar with an event of the given UID.
+func (c *CalDAVClient) findByUID(ctx context.Context, uid string) ([]RemoteEvent, error) {
+	query := &caldav.CalendarQuery{
+		CompRequest: caldav.CalendarCompRequest{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CalendarCompRequest{{Name: ical.CompEvent, Props: []string{ical.PropUID, PropSyncalSource}}},
+		},
+		CompFilter: caldav.CompFilter{
+			Name: ical.CompCalendar,
+			Comps: []caldav.CompFilter{{
+				Name:  ical.CompEvent,
+				Props: []caldav.PropFilter{{Name: ical.PropUID, TextMatch: &caldav.TextMatch{Text: uid}}},
+			}},
+		},
+	}
+	objects, err := c.caldavClient.QueryCalendar(ctx, c.calendarPath, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up event %s: %w", uid, err)
+	}
+
+	var found []RemoteEvent
+	for _, obj := range objects {
+		if obj.Data == nil {
+			continue
+		}
+		for _, child := range obj.Data.Children {
+			// The server matches substrings of the UID.
+			if other, _ := child.Props.Text(ical.PropUID); child.Name != ical.CompEvent || other != uid {
+				continue
+			}
+			found = append(found, RemoteEvent{Path: obj.Path, ETag: obj.ETag, Managed: child.Props.Get(PropSyncalSource) != nil})
+			break
+		}
+	}
+	return found, nil
+}
+
+// inspectObject fetches the object at objectPath and reports whether it exists,
+// whether it carries the syncal provenance marker and its ETag.
+func (c *CalDAVClient) inspectObject(ctx context.Context, objectPath string) (objectInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.objectURL(objectPath), nil)
+	if err != nil {
+		return objectInfo{}, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", ical.MIMEType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return objectInfo{}, fmt.Errorf("failed to fetch existing event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return objectInfo{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return objectInfo{}, fmt.Errorf("unexpected status fetching existing event: %s", resp.Status)
+	}
+
+	info := objectInfo{exists: true, etag: strings.Trim(resp.Header.Get("ETag"), `"`)}
+	cal, err := ical.NewDecoder(resp.Body).Decode()
+	if err != nil {
+		return info, fmt.Errorf("failed to decode existing event: %w", err)
+	}
+	for _, child := range cal.Children {
+		if child.Name == ical.CompEvent && child.Props.Get(PropSyncalSource) != nil {
+			info.managed = true
+			break
+		}
+	}
+	return info, nil
+}
+
+// reportForeignCollision records and logs a UID collision with an object syncal doesn't own.
+func (c *CalDAVClient) reportForeignCollision(uid, operation string) {
+	total := c.foreignCollisions.Add(1)
+	c.logger.Warn("UID collision with an event not managed by syncal, leaving it untouched",
+		"uid", uid, "operation", operation, "totalCollisions", total)
+}
+
+// fromICal converts a VEVENT into the internal Event model.
+// It returns false for events that can't be represented, such as recurring and all-day events.
+func (c *CalDAVClient) fromICal(comp *ical.Component) (*models.Event, bool) {
+	uid, _ := comp.Props.Text(ical.PropUID)
+	if ics.IsRecurring(comp) {
+		c.logger.Debug("Skipping recurring iCloud event", "uid", uid)
+		return nil, false
+	}
+
+	event, err := ics.ParseEvent(comp)
+	if errors.Is(err, ics.ErrAllDay) {
+		c.logger.Debug("Skipping all-day iCloud event", "uid", uid)
+		return nil, false
+	}
+	if err != nil {
+		c.logger.Warn("Skipping invalid iCloud event", "uid", uid, "error", err)
+		return nil, false
 	}
-	return ve
+	event.Source = "icloud"
+	return event, true
 }
 
-// findCalendar discovers the user's calendars and returns the URL for the one with the matching name.
+// findCalendar discovers the user's calendars and returns the path of the one with the matching name.
 func (c *CalDAVClient) findCalendar(ctx context.Context, name string) (string, error) {
 	principalPath, err := c.caldavClient.FindCurrentUserPrincipal(ctx)
 	if err != nil {
@@ -154,8 +541,7 @@ func (c *CalDAVClient) findCalendar(ctx context.Context, name string) (string, e
 
 	for _, cal := range calendars {
 		if cal.Name == name {
-			// Return the full URL for the calendar
-			return fmt.Sprintf("%s%s", strings.TrimSuffix(iCloudCalDAVEndpoint, "/"), cal.Path), nil
+			return cal.Path, nil
 		}
 	}
 
@@ -0,0 +1,275 @@
+package icloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syncal/internal/ics"
+	"syncal/internal/models"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav/caldav"
+)
+
+// syncSlack is how far past the requested window a full fetch reaches, so the sliding sync window
+// can be served from incremental updates for a while before the next full fetch.
+const syncSlack = 24 * time.Hour
+
+// errInvalidSyncToken is returned when the server no longer accepts a sync token.
+var errInvalidSyncToken = errors.New("sync token is no longer valid")
+
+// collectionState is the calendar as known from the last full fetch and the changes since.
+type collectionState struct {
+	token      string    // The RFC 6578 sync token, empty if the server doesn't support sync-collection
+	start, end time.Time // The window of the last full fetch
+	objects    map[string]*ical.Calendar
+}
+
+// FetchEvents returns the events of the calendar that overlap the given time range.
+// Unlike ListEvents, recurring events are expanded into one event per occurrence, as ics.ExpandEvents does.
+// Objects written by syncal are skipped, so they aren't copied back to where they came from.
+//
+// The first call fetches the calendar with a calendar-query REPORT. Later calls only download the
+// objects that changed since, using the sync-collection REPORT of RFC 6578, if the server supports it.
+func (c *CalDAVClient) FetchEvents(ctx context.Context, start, end time.Time) ([]*models.Event, error) {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	state := c.sync
+	if state != nil && state.token != "" && !start.Before(state.start) && !end.After(state.end) {
+		err := c.applyChanges(ctx, state)
+		if errors.Is(err, errInvalidSyncToken) {
+			c.logger.Info("CalDAV sync token expired, fetching the whole calendar again", "calendar", c.calendarName)
+			state = nil
+		} else if err != nil {
+			return nil, err
+		}
+	} else {
+		state = nil
+	}
+	if state == nil {
+		var err error
+		if state, err = c.fetchCollection(ctx, start, end.Add(syncSlack)); err != nil {
+			return nil, err
+		}
+	}
+	c.sync = state
+
+	var events []*models.Event
+	allDay := 0
+	for _, cal := range state.objects {
+		if isManagedObject(cal) {
+			continue
+		}
+		expanded, skipped := ics.ExpandEvents(c.logger.With("calendar", c.calendarName), cal.Children, start, end)
+		for _, event := range expanded {
+			event.Source = c.sourceName()
+			event.Account, event.CalendarID = c.username, c.calendarName
+			events = append(events, event)
+		}
+		allDay += skipped
+	}
+
+	if allDay > 0 {
+		c.logger.Info("Skipping all-day events of the CalDAV calendar, which syncal doesn't sync", "calendar", c.calendarName, "count", allDay)
+	}
+
+	c.logger.Info("Successfully fetched events from CalDAV calendar", "calendar", c.calendarName, "count", len(events))
+	return events, nil
+}
+
+// fetchCollection fetches every object of the calendar with events in the given time range,
+// along with a sync token for later incremental updates.
+func (c *CalDAVClient) fetchCollection(ctx context.Context, start, end time.Time) (*collectionState, error) {
+	// The token is read first, so that changes made during the query are picked up next time.
+	token, err := c.syncToken(ctx)
+	if err != nil {
+		c.logger.Debug("CalDAV server doesn't provide a sync token, every fetch will be a full one", "error", err)
+	}
+
+	query := &caldav.CalendarQuery{
+		CompRequest: caldav.CalendarCompRequest{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CalendarCompRequest{{Name: ical.CompEvent, AllProps: true}},
+		},
+		CompFilter: caldav.CompFilter{
+			Name:  ical.CompCalendar,
+			Comps: []caldav.CompFilter{{Name: ical.CompEvent, Start: start, End: end}},
+		},
+	}
+	objects, err := c.caldavClient.QueryCalendar(ctx, c.calendarPath, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calendar: %w", err)
+	}
+
+	state := &collectionState{token: token, start: start, end: end, objects: make(map[string]*ical.Calendar)}
+	for _, obj := range objects {
+		if obj.Data != nil {
+			state.objects[obj.Path] = obj.Data
+		}
+	}
+	return state, nil
+}
+
+// applyChanges updates the state with the objects that changed since its sync token.
+func (c *CalDAVClient) applyChanges(ctx context.Context, state *collectionState) error {
+	ms, err := c.davRequest(ctx, "REPORT", syncCollectionRequest{
+		SyncToken: state.token,
+		SyncLevel: "1",
+		Prop:      &davProp{GetETag: &struct{}{}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var changed []string
+	for _, resp := range ms.Responses {
+		u, err := url.Parse(resp.Href)
+		if err != nil {
+			continue
+		}
+		href := u.Path
+		if path.Clean(href) == path.Clean(c.calendarPath) {
+			continue
+		}
+		if strings.Contains(resp.Status, " 404 ") {
+			delete(state.objects, href)
+			continue
+		}
+		changed = append(changed, href)
+	}
+
+	if len(changed) > 0 {
+		objects, err := c.caldavClient.MultiGetCalendar(ctx, c.calendarPath, &caldav.CalendarMultiGet{
+			Paths: changed,
+			CompRequest: caldav.CalendarCompRequest{
+				Name:  ical.CompCalendar,
+				Comps: []caldav.CalendarCompRequest{{Name: ical.CompEvent, AllProps: true}},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fetch changed objects: %w", err)
+		}
+		for _, obj := range objects {
+			if obj.Data != nil {
+				state.objects[obj.Path] = obj.Data
+			}
+		}
+	}
+
+	c.logger.Debug("Applied CalDAV changes", "calendar", c.calendarName, "changed", len(changed), "responses", len(ms.Responses))
+	state.token = ms.SyncToken
+	return nil
+}
+
+// syncToken returns the current sync token of the calendar collection.
+func (c *CalDAVClient) syncToken(ctx context.Context) (string, error) {
+	ms, err := c.davRequest(ctx, "PROPFIND", propfindRequest{Prop: davProp{SyncToken: &struct{}{}}})
+	if err != nil {
+		return "", err
+	}
+	for _, resp := range ms.Responses {
+		for _, ps := range resp.Propstats {
+			if ps.Prop.SyncToken != "" {
+				return ps.Prop.SyncToken, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no sync token in response")
+}
+
+// davRequest sends a WebDAV request with an XML body to the calendar collection itself (depth 0)
+// and decodes the multistatus response.
+func (c *CalDAVClient) davRequest(ctx context.Context, method string, body any) (*multiStatus, error) {
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(c.calendarPath), bytes.NewReader(append([]byte(xml.Header), data...)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "0")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	// RFC 6578 answers an expired or unknown token with a DAV:valid-sync-token precondition failure.
+	if method == "REPORT" && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusConflict ||
+		resp.StatusCode == http.StatusPreconditionFailed) {
+		return nil, errInvalidSyncToken
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("unexpected status for %s request: %s", method, resp.Status)
+	}
+
+	ms := &multiStatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(ms); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	return ms, nil
+}
+
+// sourceName returns the models.Event Source value used for events read from the calendar.
+// iCloud calendars keep the "icloud" value events had when they were read with ListEvents.
+func (c *CalDAVClient) sourceName() string {
+	if c.IsICloud() {
+		return "icloud"
+	}
+	return fmt.Sprintf("caldav-%s", c.calendarName)
+}
+
+// isManagedObject reports whether any VEVENT of a calendar object carries the syncal provenance marker.
+func isManagedObject(cal *ical.Calendar) bool {
+	for _, child := range cal.Children {
+		if child.Name == ical.CompEvent && ics.IsManaged(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebDAV request and response bodies that go-webdav doesn't expose for CalDAV.
+
+type syncCollectionRequest struct {
+	XMLName   xml.Name `xml:"DAV: sync-collection"`
+	SyncToken string   `xml:"DAV: sync-token"`
+	SyncLevel string   `xml:"DAV: sync-level"`
+	Prop      *davProp `xml:"DAV: prop"`
+}
+
+type propfindRequest struct {
+	XMLName xml.Name `xml:"DAV: propfind"`
+	Prop    davProp  `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	GetETag   *struct{} `xml:"DAV: getetag,omitempty"`
+	SyncToken *struct{} `xml:"DAV: sync-token,omitempty"`
+}
+
+type multiStatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Status    string `xml:"DAV: status"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ETag      string `xml:"DAV: getetag"`
+				SyncToken string `xml:"DAV: sync-token"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+	SyncToken string `xml:"DAV: sync-token"`
+}
@@ -0,0 +1,205 @@
+package ics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"syncal/internal/models"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+const (
+	// PropSyncalSource is the provenance marker written on every VEVENT created by syncal.
+	// Its value is the source the event was synced from (e.g., "google-primary").
+	PropSyncalSource = "X-SYNCAL-SOURCE"
+	// PropSyncalPipeline records the syncal pipeline that wrote the VEVENT.
+	PropSyncalPipeline = "X-SYNCAL-PIPELINE"
+	// propAltDescription holds an HTML version of DESCRIPTION.
+	propAltDescription = "X-ALT-DESC"
+	// paramFilename is the title of an ATTACH property, as written by Google Calendar.
+	paramFilename = "FILENAME"
+)
+
+// NewCalendar returns an empty VCALENDAR with the properties syncal writes.
+func NewCalendar() *ical.Calendar {
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, "-//syncal//EN")
+	return cal
+}
+
+// EncodeCalendar writes cal to w. Unlike ical.Encoder, it also writes calendars without any
+// component, which is what an ICS file or feed with no upcoming events looks like.
+func EncodeCalendar(w io.Writer, cal *ical.Calendar) error {
+	if len(cal.Children) > 0 {
+		return ical.NewEncoder(w).Encode(cal)
+	}
+
+	// Encode the calendar with a placeholder event, then cut the event out again.
+	placeholder := ical.NewComponent(ical.CompEvent)
+	placeholder.Props.SetText(ical.PropUID, "placeholder")
+	placeholder.Props.SetDateTime(ical.PropDateTimeStamp, time.Unix(0, 0).UTC())
+	withEvent := &ical.Calendar{Component: &ical.Component{Name: cal.Name, Props: cal.Props, Children: []*ical.Component{placeholder}}}
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(withEvent); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	begin := bytes.Index(data, []byte("BEGIN:VEVENT\r\n"))
+	end := bytes.Index(data, []byte("END:VEVENT\r\n"))
+	if begin < 0 || end < begin {
+		return fmt.Errorf("failed to encode empty calendar")
+	}
+	data = append(data[:begin:begin], data[end+len("END:VEVENT\r\n"):]...)
+	_, err := w.Write(data)
+	return err
+}
+
+// EncodeEvent converts an internal Event model to a VEVENT.
+// When managed is set, the syncal provenance marker is added.
+func EncodeEvent(event *models.Event, managed bool) *ical.Component {
+	ve := ical.NewComponent(ical.CompEvent)
+	ve.Props.SetText(ical.PropUID, event.UID)
+	ve.Props.SetText(ical.PropSummary, event.Title)
+	ve.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+	ve.Props.SetDateTime(ical.PropDateTimeStart, event.StartTime)
+	ve.Props.SetDateTime(ical.PropDateTimeEnd, event.EndTime)
+	if managed {
+		ve.Props.SetText(PropSyncalSource, event.Source)
+		if event.Pipeline != "" {
+			ve.Props.SetText(PropSyncalPipeline, event.Pipeline)
+		}
+	}
+
+	if event.Description != "" {
+		ve.Props.SetText(ical.PropDescription, event.Description)
+	}
+	// Outlook and Thunderbird show the HTML version, other clients the plain text of DESCRIPTION.
+	if event.DescriptionHTML != "" {
+		p := ical.NewProp(propAltDescription)
+		p.SetText(event.DescriptionHTML)
+		p.Params.Set(ical.ParamFormatType, "text/html")
+		ve.Props.Add(p)
+	}
+	if event.Location != "" {
+		ve.Props.SetText(ical.PropLocation, event.Location)
+	}
+	if event.Organizer != "" {
+		p := ical.NewProp(ical.PropOrganizer)
+		p.Value = "mailto:" + event.Organizer
+		if event.OrganizerName != "" {
+			p.Params.Set(ical.ParamCommonName, paramValue(event.OrganizerName))
+		}
+		ve.Props.Add(p)
+	}
+	for _, attendee := range event.Attendees {
+		ve.Props.Add(encodeAttendee(attendee))
+	}
+	if event.Status != "" {
+		ve.Props.SetText(ical.PropStatus, strings.ToUpper(event.Status))
+	}
+	if event.Transparency != "" {
+		ve.Props.SetText(ical.PropTransparency, strings.ToUpper(event.Transparency))
+	}
+	// Google's "default" visibility is the calendar's default, which iCalendar expresses by leaving CLASS out.
+	if event.Visibility != "" && event.Visibility != "default" {
+		ve.Props.SetText(ical.PropClass, strings.ToUpper(event.Visibility))
+	}
+	if event.Color != "" {
+		ve.Props.SetText(ical.PropColor, event.Color)
+	}
+	if len(event.Categories) > 0 {
+		p := ical.NewProp(ical.PropCategories)
+		p.SetTextList(event.Categories)
+		ve.Props.Add(p)
+	}
+	if event.Conference.URL != "" {
+		// URL is what Apple Calendar offers to open, CONFERENCE (RFC 7986) is understood by newer clients.
+		u := ical.NewProp(ical.PropURL)
+		u.Value = event.Conference.URL
+		ve.Props.Set(u)
+		p := ical.NewProp(ical.PropConference)
+		p.Value = event.Conference.URL
+		p.Params.Set(ical.ParamValue, string(ical.ValueURI))
+		p.Params.Set(ical.ParamFeature, string(ical.ConferenceVideo))
+		if event.Conference.Name != "" {
+			p.Params.Set(ical.ParamLabel, paramValue(event.Conference.Name))
+		}
+		ve.Props.Add(p)
+	}
+	for _, a := range event.Attachments {
+		p := ical.NewProp(ical.PropAttach)
+		p.Value = a.URL
+		if a.Title != "" {
+			p.Params.Set(paramFilename, paramValue(a.Title))
+		}
+		if a.MimeType != "" {
+			p.Params.Set(ical.ParamFormatType, a.MimeType)
+		}
+		ve.Props.Add(p)
+	}
+	for _, minutes := range event.Reminders {
+		ve.Children = append(ve.Children, encodeAlarm(event.Title, minutes))
+	}
+	return ve
+}
+
+// partStats maps the response statuses of attendees to the iCalendar PARTSTAT.
+var partStats = map[string]string{
+	"needsAction": "NEEDS-ACTION",
+	"accepted":    "ACCEPTED",
+	"tentative":   "TENTATIVE",
+	"declined":    "DECLINED",
+}
+
+// encodeAttendee returns the ATTENDEE property of a guest, with its name, role and response.
+func encodeAttendee(attendee models.Attendee) *ical.Prop {
+	p := ical.NewProp(ical.PropAttendee)
+	p.Value = "mailto:" + attendee.Email
+	if attendee.Name != "" {
+		p.Params.Set(ical.ParamCommonName, paramValue(attendee.Name))
+	}
+	switch {
+	case attendee.Resource:
+		p.Params.Set(ical.ParamCalendarUserType, "RESOURCE")
+		p.Params.Set(ical.ParamRole, "NON-PARTICIPANT")
+	case attendee.Optional:
+		p.Params.Set(ical.ParamRole, "OPT-PARTICIPANT")
+	default:
+		p.Params.Set(ical.ParamRole, "REQ-PARTICIPANT")
+	}
+	if partStat, ok := partStats[attendee.ResponseStatus]; ok {
+		p.Params.Set(ical.ParamParticipationStatus, partStat)
+	}
+	if attendee.ResponseStatus == "needsAction" {
+		p.Params.Set(ical.ParamRSVP, "TRUE")
+	}
+	return p
+}
+
+// paramValue makes s safe to use as a parameter value, which can't contain double quotes or line breaks.
+func paramValue(s string) string {
+	return strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(s)
+}
+
+// encodeAlarm returns a VALARM that shows the title of an event the given minutes before it starts.
+func encodeAlarm(title string, minutes int) *ical.Component {
+	if title == "" {
+		title = "Reminder"
+	}
+	alarm := ical.NewComponent(ical.CompAlarm)
+	alarm.Props.SetText(ical.PropAction, "DISPLAY")
+	alarm.Props.SetText(ical.PropDescription, title)
+	trigger := ical.NewProp(ical.PropTrigger)
+	trigger.Value = fmt.Sprintf("-PT%dM", minutes)
+	alarm.Props.Set(trigger)
+	return alarm
+}
+
+// IsManaged reports whether a VEVENT carries the syncal provenance marker.
+func IsManaged(comp *ical.Component) bool {
+	return comp.Props.Get(PropSyncalSource) != nil
+}
@@ -0,0 +1,293 @@
+package ics
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"syncal/internal/htmltext"
+	"syncal/internal/models"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// ErrAllDay is returned for all-day events, which syncal does not sync.
+var ErrAllDay = errors.New("all-day events are not supported")
+
+// IsRecurring reports whether a VEVENT is a recurring event or an overridden instance of one.
+func IsRecurring(comp *ical.Component) bool {
+	return comp.Props.Get(ical.PropRecurrenceRule) != nil || comp.Props.Get(ical.PropRecurrenceID) != nil
+}
+
+// propGoogleConference holds the Google Meet link of events exported by Google Calendar.
+const propGoogleConference = "X-GOOGLE-CONFERENCE"
+
+// responseStatuses maps the iCalendar PARTSTAT of attendees to the response statuses of Google Calendar.
+var responseStatuses = map[string]string{
+	"NEEDS-ACTION": "needsAction",
+	"ACCEPTED":     "accepted",
+	"TENTATIVE":    "tentative",
+	"DECLINED":     "declined",
+}
+
+// ParseEvent converts a VEVENT into the internal Event model, using its UID as the event ID.
+// Recurrence properties are ignored, callers decide how recurring events are handled.
+func ParseEvent(comp *ical.Component) (*models.Event, error) {
+	ve := ical.Event{Component: comp}
+	uid, _ := ve.Props.Text(ical.PropUID)
+
+	startProp := ve.Props.Get(ical.PropDateTimeStart)
+	if startProp == nil {
+		return nil, fmt.Errorf("event %s has no start time", uid)
+	}
+	if startProp.ValueType() == ical.ValueDate || len(startProp.Value) == len("20060102") {
+		return nil, ErrAllDay
+	}
+
+	startTime, err := ve.DateTimeStart(time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time: %w", err)
+	}
+	endTime, err := ve.DateTimeEnd(time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end time: %w", err)
+	}
+
+	event := &models.Event{
+		ID:        uid,
+		UID:       uid,
+		StartTime: startTime,
+		EndTime:   endTime,
+	}
+	if p := ve.Props.Get(ical.PropLastModified); p != nil {
+		event.Updated, _ = p.DateTime(time.UTC)
+	}
+	event.Title, _ = ve.Props.Text(ical.PropSummary)
+	event.Description, _ = ve.Props.Text(ical.PropDescription)
+	// Clients that only know DESCRIPTION leave X-ALT-DESC as it was when they edit the description,
+	// so the HTML version is only kept if it still says the same.
+	if p := ve.Props.Get(propAltDescription); p != nil && strings.EqualFold(p.Params.Get(ical.ParamFormatType), "text/html") {
+		if alt, _ := p.Text(); htmltext.ToText(alt) == event.Description {
+			event.DescriptionHTML = alt
+		}
+	}
+	// Some feeds, like those of Google Calendar, put the HTML in DESCRIPTION itself.
+	if event.DescriptionHTML == "" && htmltext.IsHTML(event.Description) {
+		event.Description, event.DescriptionHTML = htmltext.ToText(event.Description), event.Description
+	}
+	event.Location, _ = ve.Props.Text(ical.PropLocation)
+	if p := ve.Props.Get(ical.PropOrganizer); p != nil {
+		event.Organizer = strings.TrimPrefix(strings.ToLower(p.Value), "mailto:")
+		event.OrganizerName = p.Params.Get(ical.ParamCommonName)
+	}
+	for _, p := range ve.Props.Values(ical.PropAttendee) {
+		userType := strings.ToUpper(p.Params.Get(ical.ParamCalendarUserType))
+		event.Attendees = append(event.Attendees, models.Attendee{
+			Email:          strings.TrimPrefix(strings.ToLower(p.Value), "mailto:"),
+			Name:           p.Params.Get(ical.ParamCommonName),
+			ResponseStatus: responseStatuses[strings.ToUpper(p.Params.Get(ical.ParamParticipationStatus))],
+			Optional:       strings.EqualFold(p.Params.Get(ical.ParamRole), "OPT-PARTICIPANT"),
+			Resource:       userType == "RESOURCE" || userType == "ROOM",
+		})
+	}
+	if class, _ := ve.Props.Text(ical.PropClass); class != "" {
+		event.Visibility = strings.ToLower(class)
+	}
+	if status, _ := ve.Props.Text(ical.PropStatus); strings.EqualFold(status, "CONFIRMED") || strings.EqualFold(status, "TENTATIVE") {
+		event.Status = strings.ToLower(status)
+	}
+	if transp, _ := ve.Props.Text(ical.PropTransparency); transp != "" {
+		event.Transparency = strings.ToLower(transp)
+	}
+	for _, p := range ve.Props.Values(ical.PropCategories) {
+		categories, _ := p.TextList()
+		event.Categories = append(event.Categories, categories...)
+	}
+	if p := ve.Props.Get(ical.PropURL); p != nil {
+		event.Link = p.Value
+	}
+	event.Color, _ = ve.Props.Text(ical.PropColor)
+	event.Attachments = parseAttachments(comp)
+	event.Conference = parseConference(comp)
+	event.Reminders = parseAlarms(comp)
+	return event, nil
+}
+
+// parseAttachments returns the files a VEVENT links to. Files embedded in the event are left out.
+func parseAttachments(comp *ical.Component) []models.Attachment {
+	var attachments []models.Attachment
+	for _, p := range comp.Props.Values(ical.PropAttach) {
+		if p.ValueType() == ical.ValueBinary || p.Params.Get(ical.ParamEncoding) != "" {
+			continue
+		}
+		title := p.Params.Get(paramFilename)
+		if title == "" {
+			title = p.Params.Get("X-APPLE-FILENAME")
+		}
+		attachments = append(attachments, models.Attachment{Title: title, URL: p.Value, MimeType: p.Params.Get(ical.ParamFormatType)})
+	}
+	return attachments
+}
+
+// parseConference returns the video call of a VEVENT, from its CONFERENCE properties (RFC 7986)
+// or the X-GOOGLE-CONFERENCE property of Google Calendar exports.
+func parseConference(comp *ical.Component) models.Conference {
+	conferences := comp.Props.Values(ical.PropConference)
+	for _, p := range conferences {
+		for _, feature := range p.Params.Values(ical.ParamFeature) {
+			if strings.EqualFold(feature, string(ical.ConferenceVideo)) {
+				return models.Conference{Name: p.Params.Get(ical.ParamLabel), URL: p.Value}
+			}
+		}
+	}
+	if len(conferences) > 0 {
+		return models.Conference{Name: conferences[0].Params.Get(ical.ParamLabel), URL: conferences[0].Value}
+	}
+	if url, _ := comp.Props.Text(propGoogleConference); url != "" {
+		return models.Conference{Name: "Google Meet", URL: url}
+	}
+	return models.Conference{}
+}
+
+// parseAlarms returns the alarms of a VEVENT as minutes before its start. Alarms set at a fixed time
+// or relative to the end of the event are ignored.
+func parseAlarms(comp *ical.Component) []int {
+	var minutes []int
+	for _, child := range comp.Children {
+		if child.Name != ical.CompAlarm {
+			continue
+		}
+		p := child.Props.Get(ical.PropTrigger)
+		if p == nil || strings.EqualFold(p.Params.Get(ical.ParamRelated), "END") {
+			continue
+		}
+		before, err := p.Duration()
+		if err != nil || before > 0 {
+			continue
+		}
+		minutes = append(minutes, int(-before/time.Minute))
+	}
+	return minutes
+}
+
+// ExpandEvents returns the events among the given components that overlap the time range from start to end.
+// Recurring events are expanded into one event per occurrence, each with its own ID and UID made of
+// the UID of the series and the start of the occurrence, and overridden occurrences replace the ones
+// they stand for. All-day and cancelled events are skipped, and the number of all-day events
+// (counting a series once) in the time range is returned along with the events.
+func ExpandEvents(logger *slog.Logger, comps []*ical.Component, start, end time.Time) ([]*models.Event, int) {
+	// Overridden occurrences are indexed by the UID of their series and the occurrence they replace.
+	overrides := make(map[string]map[time.Time]bool)
+	for _, child := range comps {
+		if child.Name != ical.CompEvent {
+			continue
+		}
+		if p := child.Props.Get(ical.PropRecurrenceID); p != nil {
+			recurrenceID, err := p.DateTime(time.UTC)
+			if err != nil {
+				continue
+			}
+			uid, _ := child.Props.Text(ical.PropUID)
+			if overrides[uid] == nil {
+				overrides[uid] = make(map[time.Time]bool)
+			}
+			overrides[uid][recurrenceID.UTC()] = true
+		}
+	}
+
+	var events []*models.Event
+	allDay := 0
+	for _, child := range comps {
+		if child.Name != ical.CompEvent {
+			continue
+		}
+		uid, _ := child.Props.Text(ical.PropUID)
+		if status, _ := child.Props.Text(ical.PropStatus); strings.EqualFold(status, string(ical.EventCancelled)) {
+			continue
+		}
+
+		event, err := ParseEvent(child)
+		if errors.Is(err, ErrAllDay) {
+			if allDayOverlaps(child, start, end) {
+				logger.Debug("Skipping all-day event", "uid", uid)
+				allDay++
+			}
+			continue
+		}
+		if err != nil {
+			logger.Warn("Skipping invalid event", "uid", uid, "error", err)
+			continue
+		}
+
+		if p := child.Props.Get(ical.PropRecurrenceID); p != nil {
+			recurrenceID, err := p.DateTime(time.UTC)
+			if err != nil {
+				logger.Warn("Skipping event with invalid recurrence ID", "uid", uid, "error", err)
+				continue
+			}
+			if overlaps(event.StartTime, event.EndTime, start, end) {
+				events = append(events, occurrence(event, recurrenceID, event.StartTime))
+			}
+			continue
+		}
+
+		set, err := child.RecurrenceSet(time.UTC)
+		if err != nil {
+			logger.Warn("Skipping event with invalid recurrence", "uid", uid, "error", err)
+			continue
+		}
+		if set == nil {
+			if overlaps(event.StartTime, event.EndTime, start, end) {
+				events = append(events, event)
+			}
+			continue
+		}
+
+		// Start early enough to include occurrences that are already in progress.
+		duration := event.EndTime.Sub(event.StartTime)
+		for _, t := range set.Between(start.Add(-duration), end, true) {
+			if overrides[uid][t.UTC()] || !overlaps(t, t.Add(duration), start, end) {
+				continue
+			}
+			events = append(events, occurrence(event, t, t))
+		}
+	}
+	return events, allDay
+}
+
+// allDayOverlaps reports whether an all-day event, or any occurrence of it, overlaps the time range.
+// Dates are taken as UTC days, which is close enough for counting the events that are skipped.
+func allDayOverlaps(comp *ical.Component, start, end time.Time) bool {
+	ve := ical.Event{Component: comp}
+	first, err := ve.DateTimeStart(time.UTC)
+	if err != nil {
+		return false
+	}
+	last, err := ve.DateTimeEnd(time.UTC)
+	if err != nil || !last.After(first) {
+		last = first.Add(24 * time.Hour)
+	}
+	duration := last.Sub(first)
+	set, err := comp.RecurrenceSet(time.UTC)
+	if err != nil || set == nil {
+		return overlaps(first, last, start, end)
+	}
+	return len(set.Between(start.Add(-duration), end, true)) > 0
+}
+
+// occurrence returns a copy of the event for a single occurrence of a recurring event,
+// starting at start and identified by the occurrence it stands for.
+func occurrence(event *models.Event, recurrenceID, start time.Time) *models.Event {
+	o := *event
+	o.ID = fmt.Sprintf("%s_%s", event.UID, recurrenceID.UTC().Format("20060102T150405Z"))
+	o.UID = o.ID
+	o.StartTime = start.UTC()
+	o.EndTime = start.UTC().Add(event.EndTime.Sub(event.StartTime))
+	return &o
+}
+
+// overlaps reports whether the time range from start to end overlaps the window.
+func overlaps(start, end, windowStart, windowEnd time.Time) bool {
+	return end.After(windowStart) && start.Before(windowEnd)
+}
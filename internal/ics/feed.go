@@ -0,0 +1,171 @@
+package ics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syncal/internal/models"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// Feed is an iCalendar feed read from a URL or a local file.
+// The feed is only downloaded or read again when it changed, using the ETag and Last-Modified
+// headers for URLs and the modification time for files.
+type Feed struct {
+	logger     *slog.Logger
+	httpClient *http.Client
+	location   string
+	name       string
+
+	// Validators and content of the last successful fetch.
+	etag         string
+	lastModified string
+	modTime      time.Time
+	cal          *ical.Calendar
+}
+
+// NewFeed creates a Feed for the given URL or file path. webcal:// URLs are fetched over HTTPS.
+// The name identifies the feed in logs and the sync state, so that secret URLs are never recorded.
+// If it is empty, the host of the URL or the name of the file is used.
+func NewFeed(logger *slog.Logger, location, name string) *Feed {
+	if rest, ok := strings.CutPrefix(location, "webcal://"); ok {
+		location = "https://" + rest
+	}
+	if name == "" {
+		if u, err := url.Parse(location); err == nil && u.Host != "" {
+			name = u.Host
+		} else {
+			name = filepath.Base(location)
+		}
+	}
+	return &Feed{
+		logger:     logger,
+		httpClient: &http.Client{Timeout: time.Minute},
+		location:   location,
+		name:       name,
+	}
+}
+
+// Key returns the "account/calendar" key that identifies the feed in sync runs.
+func (f *Feed) Key() string {
+	return "ics/" + f.name
+}
+
+// Events returns the events of the feed that overlap the given time range.
+// Recurring events are expanded as described for ExpandEvents.
+func (f *Feed) Events(ctx context.Context, start, end time.Time) ([]*models.Event, error) {
+	if err := f.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	events, allDay := ExpandEvents(f.logger.With("feed", f.name), f.cal.Children, start, end)
+	for _, event := range events {
+		event.Source = SourceName(f.name)
+		event.Account, event.CalendarID = "ics", f.name
+	}
+
+	if allDay > 0 {
+		f.logger.Info("Skipping all-day events of the feed, which syncal doesn't sync", "feed", f.name, "count", allDay)
+	}
+	f.logger.Info("Successfully fetched events from feed", "feed", f.name, "count", len(events))
+	return events, nil
+}
+
+// refresh fetches the feed again if it changed since the last fetch.
+func (f *Feed) refresh(ctx context.Context) error {
+	var body io.ReadCloser
+	var err error
+	if strings.HasPrefix(f.location, "http://") || strings.HasPrefix(f.location, "https://") {
+		body, err = f.download(ctx)
+	} else {
+		body, err = f.open()
+	}
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		f.logger.Debug("Feed not modified since the last fetch", "feed", f.name)
+		return nil
+	}
+	defer body.Close()
+
+	cal, err := ical.NewDecoder(body).Decode()
+	if err != nil {
+		// Forget the validators, so the broken content isn't mistaken for the last good one.
+		f.etag, f.lastModified, f.modTime = "", "", time.Time{}
+		return fmt.Errorf("failed to parse feed %s: %w", f.name, err)
+	}
+	f.cal = cal
+	return nil
+}
+
+// download requests the feed URL, returning a nil body if the server reports that it wasn't modified.
+func (f *Feed) download(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed URL for %s: %w", f.name, err)
+	}
+	req.Header.Set("User-Agent", "syncal/1.0")
+	if f.cal != nil {
+		if f.etag != "" {
+			req.Header.Set("If-None-Match", f.etag)
+		}
+		if f.lastModified != "" {
+			req.Header.Set("If-Modified-Since", f.lastModified)
+		}
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		// The error includes the URL, which may contain a secret.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to fetch feed %s: %w", f.name, err)
+	}
+	if resp.StatusCode == http.StatusNotModified && f.cal != nil {
+		resp.Body.Close()
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch feed %s: unexpected status %s", f.name, resp.Status)
+	}
+
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	return resp.Body, nil
+}
+
+// open opens the feed file, returning a nil body if it wasn't modified since the last fetch.
+func (f *Feed) open() (io.ReadCloser, error) {
+	info, err := os.Stat(f.location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed %s: %w", f.name, err)
+	}
+	if f.cal != nil && info.ModTime().Equal(f.modTime) {
+		return nil, nil
+	}
+
+	file, err := os.Open(f.location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed %s: %w", f.name, err)
+	}
+	f.modTime = info.ModTime()
+	return file, nil
+}
+
+// SourceName returns the models.Event Source value used for events of the named feed.
+func SourceName(name string) string {
+	return fmt.Sprintf("ics-%s", name)
+}
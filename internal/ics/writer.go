@@ -0,0 +1,349 @@
+package ics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syncal/internal/models"
+
+	"github.com/emersion/go-ical"
+)
+
+// ErrForeignObject is returned when an operation would modify an event that syncal did not create.
+var ErrForeignObject = errors.New("ics event is not managed by syncal")
+
+// FileWriter writes events into a single .ics file, keeping any other events already in it.
+// Between Begin and Flush, changes are made to the calendar in memory and the file is written once;
+// otherwise it is read and written again for every change. Only one target may write to a file.
+type FileWriter struct {
+	logger *slog.Logger
+	path   string
+
+	mu    sync.Mutex
+	batch *ical.Calendar // The calendar changed since Begin, nil outside of a batch
+	dirty bool           // The batch has changes to write
+
+	// foreignCollisions counts UID collisions with events that syncal does not own.
+	foreignCollisions atomic.Int64
+}
+
+// NewFileWriter creates a FileWriter for the file at path, which is created on the first write.
+func NewFileWriter(logger *slog.Logger, path string) *FileWriter {
+	return &FileWriter{logger: logger, path: path}
+}
+
+// SyncEvent creates or updates the event with the same UID in the file and returns the ETag of the written event.
+// Unless owned is set, an existing event that syncal didn't create is left untouched and ErrForeignObject is returned.
+func (w *FileWriter) SyncEvent(ctx context.Context, event *models.Event, owned bool) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cal, err := w.calendar()
+	if err != nil {
+		return "", err
+	}
+
+	vevent := EncodeEvent(event, true)
+	replaced := false
+	for i, child := range cal.Children {
+		if !isEvent(child, event.UID) {
+			continue
+		}
+		if !owned && !IsManaged(child) {
+			w.reportForeignCollision(event.UID, "overwrite")
+			return "", fmt.Errorf("refusing to overwrite event %s: %w", event.UID, ErrForeignObject)
+		}
+		cal.Children[i] = vevent
+		replaced = true
+		break
+	}
+	if !replaced {
+		cal.Children = append(cal.Children, vevent)
+	}
+
+	if err := w.save(cal); err != nil {
+		return "", err
+	}
+	w.logger.Info("Successfully synced event to ICS file", "eventTitle", event.Title, "file", w.path)
+	return eventETag(vevent)
+}
+
+// DeleteEvent removes the event with the given UID from the file.
+// Like SyncEvent, it refuses to delete events that syncal does not own.
+// Deleting an event that no longer exists is not an error.
+func (w *FileWriter) DeleteEvent(ctx context.Context, uid string, owned bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cal, err := w.calendar()
+	if err != nil {
+		return err
+	}
+
+	for i, child := range cal.Children {
+		if !isEvent(child, uid) {
+			continue
+		}
+		if !owned && !IsManaged(child) {
+			w.reportForeignCollision(uid, "delete")
+			return fmt.Errorf("refusing to delete event %s: %w", uid, ErrForeignObject)
+		}
+		cal.Children = append(cal.Children[:i], cal.Children[i+1:]...)
+		if err := w.save(cal); err != nil {
+			return err
+		}
+		w.logger.Info("Successfully deleted event from ICS file", "uid", uid, "file", w.path)
+		return nil
+	}
+
+	w.logger.Debug("Event already absent from ICS file, nothing to delete.", "uid", uid)
+	return nil
+}
+
+// ForeignCollisions returns how many UID collisions with foreign events were detected so far.
+func (w *FileWriter) ForeignCollisions() int64 {
+	return w.foreignCollisions.Load()
+}
+
+// CalendarKey returns the path of the file, which identifies the calendar the writer writes to.
+func (w *FileWriter) CalendarKey() string {
+	return filepath.Clean(w.path)
+}
+
+// Begin starts a batch of changes, which are written to the file by Flush.
+// Changes made by others to the file until then are overwritten.
+func (w *FileWriter) Begin(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cal, err := w.read()
+	if err != nil {
+		return err
+	}
+	w.batch, w.dirty = cal, false
+	return nil
+}
+
+// Flush writes the changes made since Begin to the file and ends the batch.
+// If it fails, none of the changes of the batch were written.
+func (w *FileWriter) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cal, dirty := w.batch, w.dirty
+	w.batch, w.dirty = nil, false
+	if cal == nil || !dirty {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := writeCalendar(w.path, cal); err != nil {
+		return err
+	}
+	w.logger.Debug("Wrote ICS file", "file", w.path)
+	return nil
+}
+
+// calendar returns the calendar changes are made to: the one of the current batch, or else the one in the file.
+func (w *FileWriter) calendar() (*ical.Calendar, error) {
+	if w.batch != nil {
+		return w.batch, nil
+	}
+	return w.read()
+}
+
+// save writes a changed calendar to the file, unless it is the calendar of the current batch, which Flush writes.
+func (w *FileWriter) save(cal *ical.Calendar) error {
+	if cal == w.batch {
+		w.dirty = true
+		return nil
+	}
+	return writeCalendar(w.path, cal)
+}
+
+// read returns the calendar in the file, or an empty one if the file doesn't exist yet.
+func (w *FileWriter) read() (*ical.Calendar, error) {
+	f, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return NewCalendar(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICS file: %w", err)
+	}
+	defer f.Close()
+
+	cal, err := ical.NewDecoder(f).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ICS file %s: %w", w.path, err)
+	}
+	return cal, nil
+}
+
+// reportForeignCollision records and logs a UID collision with an event syncal doesn't own.
+func (w *FileWriter) reportForeignCollision(uid, operation string) {
+	total := w.foreignCollisions.Add(1)
+	w.logger.Warn("UID collision with an event not managed by syncal, leaving it untouched",
+		"uid", uid, "operation", operation, "file", w.path, "totalCollisions", total)
+}
+
+// DirWriter writes every event to its own .ics file in a directory, the vdir layout used by
+// tools such as vdirsyncer and khal.
+type DirWriter struct {
+	logger *slog.Logger
+	dir    string
+
+	// foreignCollisions counts UID collisions with events that syncal does not own.
+	foreignCollisions atomic.Int64
+}
+
+// NewDirWriter creates a DirWriter for the directory dir, which is created on the first write.
+func NewDirWriter(logger *slog.Logger, dir string) *DirWriter {
+	return &DirWriter{logger: logger, dir: dir}
+}
+
+// SyncEvent creates or updates the file of the event and returns the ETag of the written event.
+// Unless owned is set, an existing file that syncal didn't create is left untouched and ErrForeignObject is returned.
+func (w *DirWriter) SyncEvent(ctx context.Context, event *models.Event, owned bool) (string, error) {
+	path := w.eventPath(event.UID)
+	if err := w.checkOwnership(path, event.UID, "overwrite", owned); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create ICS directory: %w", err)
+	}
+	vevent := EncodeEvent(event, true)
+	cal := NewCalendar()
+	cal.Children = append(cal.Children, vevent)
+	if err := writeCalendar(path, cal); err != nil {
+		return "", err
+	}
+
+	w.logger.Info("Successfully synced event to ICS directory", "eventTitle", event.Title, "file", path)
+	return eventETag(vevent)
+}
+
+// DeleteEvent removes the file of the event with the given UID.
+// Like SyncEvent, it refuses to delete files that syncal does not own.
+// Deleting an event that no longer exists is not an error.
+func (w *DirWriter) DeleteEvent(ctx context.Context, uid string, owned bool) error {
+	path := w.eventPath(uid)
+	if err := w.checkOwnership(path, uid, "delete", owned); err != nil {
+		return err
+	}
+
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		w.logger.Debug("Event already absent from ICS directory, nothing to delete.", "uid", uid)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete ICS file: %w", err)
+	}
+
+	w.logger.Info("Successfully deleted event from ICS directory", "file", path)
+	return nil
+}
+
+// ForeignCollisions returns how many UID collisions with foreign events were detected so far.
+func (w *DirWriter) ForeignCollisions() int64 {
+	return w.foreignCollisions.Load()
+}
+
+// CalendarKey returns the path of the directory, which identifies the calendar the writer writes to.
+func (w *DirWriter) CalendarKey() string {
+	return filepath.Clean(w.dir)
+}
+
+// eventPath returns the path of the file syncal writes for the given UID.
+// UIDs that aren't safe as file names are hashed.
+func (w *DirWriter) eventPath(uid string) string {
+	name := uid
+	if name == "" || strings.ContainsAny(name, `/\:*?"<>|`) || strings.HasPrefix(name, ".") {
+		sum := sha1.Sum([]byte(uid))
+		name = hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(w.dir, name+".ics")
+}
+
+// checkOwnership returns ErrForeignObject if the file exists but was not written by syncal and owned is not set.
+func (w *DirWriter) checkOwnership(path, uid, operation string, owned bool) error {
+	if owned {
+		return nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ICS file: %w", err)
+	}
+	defer f.Close()
+
+	cal, err := ical.NewDecoder(f).Decode()
+	if err == nil {
+		for _, child := range cal.Children {
+			if child.Name == ical.CompEvent && IsManaged(child) {
+				return nil
+			}
+		}
+	}
+
+	total := w.foreignCollisions.Add(1)
+	w.logger.Warn("UID collision with an event not managed by syncal, leaving it untouched",
+		"uid", uid, "operation", operation, "file", path, "totalCollisions", total)
+	return fmt.Errorf("refusing to %s event %s: %w", operation, uid, ErrForeignObject)
+}
+
+// isEvent reports whether comp is the VEVENT with the given UID.
+func isEvent(comp *ical.Component, uid string) bool {
+	if comp.Name != ical.CompEvent {
+		return false
+	}
+	v, _ := comp.Props.Text(ical.PropUID)
+	return v == uid
+}
+
+// writeCalendar encodes cal to the file at path, replacing it atomically.
+func writeCalendar(path string, cal *ical.Calendar) error {
+	var buf bytes.Buffer
+	if err := EncodeCalendar(&buf, cal); err != nil {
+		return fmt.Errorf("failed to encode calendar: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write ICS file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write ICS file: %w", err)
+	}
+	return nil
+}
+
+// eventETag returns an ETag for a written VEVENT, derived from its content.
+func eventETag(vevent *ical.Component) (string, error) {
+	cal := NewCalendar()
+	cal.Children = append(cal.Children, vevent)
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return "", fmt.Errorf("failed to encode event: %w", err)
+	}
+	sum := sha1.Sum(buf.Bytes())
+	return `"` + hex.EncodeToString(sum[:]) + `"`, nil
+}
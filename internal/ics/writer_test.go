@@ -0,0 +1,171 @@
+package ics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syncal/internal/models"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// readEvents decodes the file at path and expands its events over the day of start.
+func readEvents(t *testing.T, path string, start time.Time) map[string]*models.Event {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open written file: %v", err)
+	}
+	defer f.Close()
+	cal, err := ical.NewDecoder(f).Decode()
+	if err != nil {
+		t.Fatalf("decode written file: %v", err)
+	}
+	events := make(map[string]*models.Event)
+	expanded, _ := ExpandEvents(testLogger, cal.Children, start.Add(-time.Hour), start.Add(24*time.Hour))
+	for _, event := range expanded {
+		events[event.UID] = event
+	}
+	return events
+}
+
+func TestFileWriterRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.ics")
+	w := NewFileWriter(testLogger, path)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+
+	standup := &models.Event{
+		UID:         "standup@example.com",
+		Title:       "Standup",
+		Description: "Agenda:\n- Updates",
+		Location:    "Room 1",
+		StartTime:   start,
+		EndTime:     start.Add(15 * time.Minute),
+		Organizer:   "lead@example.com",
+		Attendees:   []models.Attendee{{Email: "bob@example.com", Name: "Bob", ResponseStatus: "accepted"}},
+		Categories:  []string{"Work"},
+		Status:      "tentative",
+		Reminders:   []int{10},
+		Conference:  models.Conference{Name: "Google Meet", URL: "https://meet.google.com/abc-defg-hij"},
+		Attachments: []models.Attachment{{Title: "Agenda", URL: "https://drive.google.com/file/d/1", MimeType: "application/pdf"}},
+		Color:       "orange",
+		Source:      "google",
+		Pipeline:    "work",
+	}
+	review := &models.Event{UID: "review@example.com", Title: "Review", StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour), Source: "google"}
+
+	if err := w.Begin(ctx); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	for _, event := range []*models.Event{standup, review} {
+		if _, err := w.SyncEvent(ctx, event, false); err != nil {
+			t.Fatalf("SyncEvent(%s): %v", event.UID, err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file was written before Flush: %v", err)
+	}
+	if err := w.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	events := readEvents(t, path, start)
+	if len(events) != 2 {
+		t.Fatalf("read %d events, want 2", len(events))
+	}
+	got := events[standup.UID]
+	if got == nil {
+		t.Fatalf("standup not found in %v", events)
+	}
+	if got.Title != standup.Title || got.Description != standup.Description || got.Location != standup.Location {
+		t.Errorf("text fields = %q, %q, %q", got.Title, got.Description, got.Location)
+	}
+	if !got.StartTime.Equal(standup.StartTime) || !got.EndTime.Equal(standup.EndTime) {
+		t.Errorf("times = %v - %v, want %v - %v", got.StartTime, got.EndTime, standup.StartTime, standup.EndTime)
+	}
+	if got.Organizer != standup.Organizer || len(got.Attendees) != 1 || got.Attendees[0] != standup.Attendees[0] {
+		t.Errorf("organizer %q, attendees %+v", got.Organizer, got.Attendees)
+	}
+	if got.Status != "tentative" || got.Color != "orange" || len(got.Categories) != 1 || got.Categories[0] != "Work" {
+		t.Errorf("status %q, color %q, categories %v", got.Status, got.Color, got.Categories)
+	}
+	if len(got.Reminders) != 1 || got.Reminders[0] != 10 {
+		t.Errorf("reminders = %v, want [10]", got.Reminders)
+	}
+	if got.Conference != standup.Conference {
+		t.Errorf("conference = %+v, want %+v", got.Conference, standup.Conference)
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0] != standup.Attachments[0] {
+		t.Errorf("attachments = %+v, want %+v", got.Attachments, standup.Attachments)
+	}
+
+	// Outside of a batch, every change is written right away.
+	if err := w.DeleteEvent(ctx, review.UID, true); err != nil {
+		t.Fatalf("DeleteEvent: %v", err)
+	}
+	if events := readEvents(t, path, start); len(events) != 1 || events[standup.UID] == nil {
+		t.Errorf("after delete, file has %v", events)
+	}
+}
+
+func TestFileWriterKeepsForeignEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.ics")
+	start := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	foreign := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//test//EN",
+		"BEGIN:VEVENT",
+		"UID:dentist@example.com",
+		"DTSTAMP:20260301T000000Z",
+		"DTSTART:20260302T150000Z",
+		"DTEND:20260302T160000Z",
+		"SUMMARY:Dentist",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if err := os.WriteFile(path, []byte(foreign), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewFileWriter(testLogger, path)
+	ctx := context.Background()
+	if err := w.Begin(ctx); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	copied := &models.Event{UID: "dentist@example.com", Title: "Copied", StartTime: start, EndTime: start.Add(time.Hour)}
+	if _, err := w.SyncEvent(ctx, copied, false); !errors.Is(err, ErrForeignObject) {
+		t.Fatalf("SyncEvent over a foreign event = %v, want ErrForeignObject", err)
+	}
+	if err := w.DeleteEvent(ctx, "dentist@example.com", false); !errors.Is(err, ErrForeignObject) {
+		t.Fatalf("DeleteEvent of a foreign event = %v, want ErrForeignObject", err)
+	}
+	standup := &models.Event{UID: "standup@example.com", Title: "Standup", StartTime: start, EndTime: start.Add(15 * time.Minute)}
+	if _, err := w.SyncEvent(ctx, standup, false); err != nil {
+		t.Fatalf("SyncEvent: %v", err)
+	}
+	if err := w.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	events := readEvents(t, path, start)
+	if events["dentist@example.com"] == nil || events["dentist@example.com"].Title != "Dentist" {
+		t.Errorf("foreign event was not kept: %v", events)
+	}
+	if events["standup@example.com"] == nil {
+		t.Errorf("synced event is missing: %v", events)
+	}
+	if got := w.ForeignCollisions(); got != 2 {
+		t.Errorf("ForeignCollisions = %d, want 2", got)
+	}
+}
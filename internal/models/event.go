@@ -1,18 +1,109 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
 
 // Event represents a standard calendar event.
 // This is an internal representation, independent of any specific calendar provider.
 type Event struct {
-	ID          string    // Unique identifier for the event (e.g., from the source calendar)
-	Title       string    // Summary or title of the event
-	Description string    // Detailed description of the event
-	StartTime   time.Time // Start time of the event
-	EndTime     time.Time // End time of the event
-	Location    string    // Location of the event
-	Organizer   string    // Organizer's email
-	Attendees   []string  // List of attendee emails
-	Source      string    // The source of the event (e.g., "google")
-	UID         string    // The iCalendar UID, used for syncing
+	ID          string     `json:"id"`                    // Unique identifier for the event (e.g., from the source calendar)
+	Title       string     `json:"title"`                 // Summary or title of the event
+	Description string     `json:"description,omitempty"` // Detailed description of the event
+	StartTime   time.Time  `json:"startTime"`             // Start time of the event
+	EndTime     time.Time  `json:"endTime"`               // End time of the event
+	Location    string     `json:"location,omitempty"`    // Location of the event
+	Organizer   string     `json:"organizer,omitempty"`   // Organizer's email
+	Attendees   []Attendee `json:"attendees,omitempty"`   // Guests of the event
+	// ResponseStatus is the calendar owner's response to the invitation: "needsAction", "accepted", "tentative"
+	// or "declined". It is empty for events without attendees or when the source doesn't tell.
+	ResponseStatus string `json:"responseStatus,omitempty"`
+	EventType      string `json:"eventType,omitempty"`     // The kind of event, e.g. "default", "focusTime" or "outOfOffice"
+	Visibility     string `json:"visibility,omitempty"`    // "default", "public", "private" or "confidential"
+	OrganizerName  string `json:"organizerName,omitempty"` // Display name of the organizer, if the source has one
+	// Status is "confirmed" or "tentative", as in the iCalendar STATUS property. Empty means confirmed.
+	Status string `json:"status,omitempty"`
+	// Color is the color of the event, as a CSS color name like in the iCalendar COLOR property (RFC 7986).
+	Color string `json:"color,omitempty"`
+	// ColorName is what the source calls the color, like the Google event color "Tomato", or the name of
+	// the calendar whose color the event has. Pipelines add it as a category.
+	ColorName string `json:"colorName,omitempty"`
+	// Conference is the video call of the event. It is the zero value for events without one.
+	Conference Conference `json:"conference,omitzero"`
+	// DescriptionHTML is the original of a description that was HTML, in which case Description is its
+	// plain text version.
+	DescriptionHTML string `json:"descriptionHtml,omitempty"`
+	// Attachments are the files linked to the event, such as Google Drive documents.
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Transparency is "opaque" for events that block time, or "transparent" for events that leave it free.
+	// Empty means opaque.
+	Transparency string    `json:"transparency,omitempty"`
+	Categories   []string  `json:"categories,omitempty"` // Category names, as in the iCalendar CATEGORIES property
+	Reminders    []int     `json:"reminders,omitempty"`  // Alerts, in minutes before the start of the event
+	Link         string    `json:"link,omitempty"`       // URL of the event in the web interface of its source
+	Source       string    `json:"source"`               // The source of the event (e.g., "google")
+	Account      string    `json:"account,omitempty"`    // The source account the event was read from
+	CalendarID   string    `json:"calendarId,omitempty"` // The source calendar the event was read from
+	UID          string    `json:"uid"`                  // The iCalendar UID, used for syncing
+	Updated      time.Time `json:"updated"`              // When the event was last modified in its source
+	Pipeline     string    `json:"pipeline,omitempty"`   // The syncal pipeline the event is synced by
+}
+
+// Clone returns a copy of the event that can be modified without affecting the original.
+func (e *Event) Clone() *Event {
+	c := *e
+	c.Attendees = slices.Clone(e.Attendees)
+	c.Categories = slices.Clone(e.Categories)
+	c.Reminders = slices.Clone(e.Reminders)
+	c.Attachments = slices.Clone(e.Attachments)
+	return &c
+}
+
+// Conference is the video call of an event, such as a Google Meet, Zoom or Teams meeting.
+type Conference struct {
+	Name string `json:"name,omitempty"` // The service, e.g. "Google Meet" or "Zoom Meeting", if known
+	URL  string `json:"url"`            // The link to join the call
+}
+
+// Attachment is a file linked to an event. Files are only referenced by their URL, never copied.
+type Attachment struct {
+	Title    string `json:"title,omitempty"`
+	URL      string `json:"url"`
+	MimeType string `json:"mimeType,omitempty"` // e.g. "application/pdf" or "application/vnd.google-apps.document"
+}
+
+// Attendee is a guest of an event.
+type Attendee struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"` // Display name, if the source has one
+	// ResponseStatus is "needsAction", "accepted", "tentative" or "declined", empty if unknown.
+	ResponseStatus string `json:"responseStatus,omitempty"`
+	// Self is set for the owner of the calendar the event was read from, when the source tells.
+	Self bool `json:"self,omitempty"`
+	// Optional is set for guests whose attendance is optional.
+	Optional bool `json:"optional,omitempty"`
+	// Resource is set for rooms and equipment booked for the event.
+	Resource bool `json:"resource,omitempty"`
+}
+
+// UnmarshalJSON also accepts a plain email, as stored in sync state snapshots before response statuses were kept.
+func (a *Attendee) UnmarshalJSON(data []byte) error {
+	var email string
+	if err := json.Unmarshal(data, &email); err == nil {
+		*a = Attendee{Email: email}
+		return nil
+	}
+	type attendee Attendee // Without the UnmarshalJSON method
+	return json.Unmarshal(data, (*attendee)(a))
+}
+
+// AttendeeEmails returns the emails of the attendees of the event.
+func (e *Event) AttendeeEmails() []string {
+	var emails []string
+	for _, a := range e.Attendees {
+		emails = append(emails, a.Email)
+	}
+	return emails
 }
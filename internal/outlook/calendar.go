@@ -0,0 +1,629 @@
+// Package outlook reads and writes Microsoft 365 and Outlook.com calendars through the Microsoft Graph API.
+package outlook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syncal/internal/models"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+)
+
+const (
+	// DefaultBaseURL is the Microsoft Graph endpoint used when no other one is configured.
+	DefaultBaseURL = "https://graph.microsoft.com/v1.0"
+	// DefaultTenant lets both work or school and personal Microsoft accounts sign in.
+	DefaultTenant = "common"
+
+	// PrimaryCalendar is the calendar ID that stands for the default calendar of the account.
+	PrimaryCalendar = "primary"
+
+	// deltaSlack is how far past the requested window a delta query reaches, so the sliding
+	// sync window can be served from the same delta link for a while before a full fetch.
+	deltaSlack = 24 * time.Hour
+
+	// graphTimeLayout is the format of the dateTime values of Graph events.
+	graphTimeLayout = "2006-01-02T15:04:05.9999999"
+)
+
+// Client provides a client for the calendars of one Microsoft account.
+type Client struct {
+	http    *http.Client
+	baseURL string
+	logger  *slog.Logger
+	account string
+
+	// Delta sync state of every calendar read so far.
+	mu     sync.Mutex
+	deltas map[string]*deltaState
+}
+
+// deltaState is what a calendarView delta query returned so far for one calendar.
+type deltaState struct {
+	link       string    // The delta link returning the changes since the last fetch
+	start, end time.Time // The window the delta query covers
+	events     map[string]*graphEvent
+	pipelines  map[string]string // The pipeline marker of events written by syncal, by event ID
+}
+
+// NewClient creates a client for the named account, using the token saved by the 'auth' command.
+// Refreshed tokens are saved again, since Microsoft rotates refresh tokens.
+// An empty baseURL uses DefaultBaseURL.
+func NewClient(ctx context.Context, logger *slog.Logger, clientID, tenant, baseURL, accountName string) (*Client, error) {
+	tokenFile := TokenFile(accountName)
+	token, err := tokenFromFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load token for outlook account %s: %w. Please run the 'auth --provider outlook' command first", accountName, err)
+	}
+
+	// The scope only matters for the auth flow, refreshing a token keeps the scope it was granted.
+	config := OAuthConfig(clientID, tenant, false)
+	ts := &savingTokenSource{
+		base:         config.TokenSource(ctx, token),
+		file:         tokenFile,
+		logger:       logger,
+		refreshToken: token.RefreshToken,
+	}
+	return New(logger, oauth2.NewClient(ctx, ts), baseURL, accountName), nil
+}
+
+// New creates a client that sends its requests with httpClient, which must authenticate them.
+// It is also how a client is pointed at a fake Graph server.
+func New(logger *slog.Logger, httpClient *http.Client, baseURL, accountName string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		http:    httpClient,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		logger:  logger,
+		account: accountName,
+		deltas:  make(map[string]*deltaState),
+	}
+}
+
+// Account returns the name of the account the client is authenticated as.
+func (c *Client) Account() string {
+	return c.account
+}
+
+// Error is an error response of the Graph API.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("graph api: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsAuthError reports whether err was caused by a token that can no longer be refreshed or was rejected,
+// meaning the account has to go through the 'auth' command again.
+func IsAuthError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	var graphErr *Error
+	return errors.As(err, &retrieveErr) || (errors.As(err, &graphErr) && graphErr.StatusCode == http.StatusUnauthorized)
+}
+
+// isStatus reports whether err is a Graph error response with the given status code.
+func isStatus(err error, code int) bool {
+	var graphErr *Error
+	return errors.As(err, &graphErr) && graphErr.StatusCode == code
+}
+
+// GetEvents fetches the events of the specified calendar that overlap the given time range.
+// Only the changes since the previous call are downloaded, using calendarView delta links.
+func (c *Client) GetEvents(ctx context.Context, calendarID string, start, end time.Time) ([]*models.Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.Debug("Fetching events", "calendarID", calendarID, "start", start, "end", end)
+	state, err := c.fetchDelta(ctx, calendarID, start, end)
+	if isStatus(err, http.StatusGone) {
+		// The delta link expired, start over with a full fetch.
+		c.logger.Info("Outlook delta link expired, fetching the whole calendar again", "calendarID", calendarID)
+		delete(c.deltas, calendarID)
+		state, err = c.fetchDelta(ctx, calendarID, start, end)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve events: %w", err)
+	}
+
+	var events []*models.Event
+	for _, item := range state.events {
+		event := c.toInternalEvent(item, calendarID)
+		if event == nil || !event.EndTime.After(start) || !event.StartTime.Before(end) {
+			continue
+		}
+		event.Pipeline = state.pipelines[item.ID]
+		events = append(events, event)
+	}
+
+	c.logger.Info("Successfully fetched events from Outlook", "count", len(events), "calendarID", calendarID)
+	return events, nil
+}
+
+// fetchDelta brings the delta state of a calendar up to date for the given time range.
+// The state is only replaced once every page was fetched, so a failed fetch can simply be retried.
+func (c *Client) fetchDelta(ctx context.Context, calendarID string, start, end time.Time) (*deltaState, error) {
+	prev := c.deltas[calendarID]
+	state := &deltaState{start: start, end: end.Add(deltaSlack), events: make(map[string]*graphEvent), pipelines: make(map[string]string)}
+	link := fmt.Sprintf("%s/calendarView/delta?startDateTime=%s&endDateTime=%s",
+		calendarPath(calendarID), state.start.UTC().Format(time.RFC3339), state.end.UTC().Format(time.RFC3339))
+	if prev != nil && !start.Before(prev.start) && !end.After(prev.end) {
+		state.start, state.end, link = prev.start, prev.end, prev.link
+		for id, item := range prev.events {
+			state.events[id] = item
+		}
+	}
+
+	changed := 0
+	for link != "" {
+		var page struct {
+			Value     []*graphEvent `json:"value"`
+			NextLink  string        `json:"@odata.nextLink"`
+			DeltaLink string        `json:"@odata.deltaLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, link, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Value {
+			changed++
+			if item.Removed != nil || item.IsCancelled {
+				delete(state.events, item.ID)
+				continue
+			}
+			state.events[item.ID] = item
+		}
+		link = page.NextLink
+		if link == "" {
+			state.link = page.DeltaLink
+		}
+	}
+
+	// Delta queries can't return extended properties, so the pipeline markers are read separately,
+	// and only when something changed.
+	if prev != nil && changed == 0 {
+		state.pipelines = prev.pipelines
+	} else {
+		pipelines, err := c.pipelineMarkers(ctx, calendarID)
+		if err != nil {
+			return nil, err
+		}
+		state.pipelines = pipelines
+	}
+
+	c.deltas[calendarID] = state
+	return state, nil
+}
+
+// pipelineMarkers returns the pipeline marker of every event of the calendar written by syncal, by event ID.
+func (c *Client) pipelineMarkers(ctx context.Context, calendarID string) (map[string]string, error) {
+	query := url.Values{
+		"$filter": {fmt.Sprintf("singleValueExtendedProperties/Any(ep: ep/id eq '%s')", propPipelineID)},
+		"$expand": {fmt.Sprintf("singleValueExtendedProperties($filter=id eq '%s')", propPipelineID)},
+		"$select": {"id"},
+	}
+	link := calendarPath(calendarID) + "/events?" + query.Encode()
+
+	pipelines := make(map[string]string)
+	for link != "" {
+		var page struct {
+			Value    []*graphEvent `json:"value"`
+			NextLink string        `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, link, nil, &page); err != nil {
+			return nil, fmt.Errorf("failed to read syncal markers: %w", err)
+		}
+		for _, item := range page.Value {
+			pipelines[item.ID] = item.property(propPipelineID)
+		}
+		link = page.NextLink
+	}
+	return pipelines, nil
+}
+
+// do sends a request to the Graph API and decodes the JSON response into out, if it isn't nil.
+// The path is relative to the base URL, unless it is a full link returned by the API.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	link := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		link = c.baseURL + path
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, link, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Times are returned in UTC and bodies as plain text, matching the internal Event model.
+	req.Header.Add("Prefer", `outlook.timezone="UTC"`)
+	req.Header.Add("Prefer", `outlook.body-content-type="text"`)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var errResp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		return &Error{StatusCode: resp.StatusCode, Code: errResp.Error.Code, Message: errResp.Error.Message}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// calendarPath returns the Graph path of a calendar of the signed-in user.
+func calendarPath(calendarID string) string {
+	if calendarID == PrimaryCalendar {
+		return "/me/calendar"
+	}
+	return "/me/calendars/" + url.PathEscape(calendarID)
+}
+
+// graphEvent is an event resource of the Graph API, with the fields syncal reads or writes.
+type graphEvent struct {
+	ID                 string              `json:"id,omitempty"`
+	ETag               string              `json:"@odata.etag,omitempty"`
+	ICalUID            string              `json:"iCalUId,omitempty"`
+	Subject            string              `json:"subject"`
+	Body               *itemBody           `json:"body,omitempty"`
+	Start              *dateTimeTimeZone   `json:"start,omitempty"`
+	End                *dateTimeTimeZone   `json:"end,omitempty"`
+	Location           *location           `json:"location,omitempty"`
+	Organizer          *recipient          `json:"organizer,omitempty"`
+	Attendees          []*recipient        `json:"attendees,omitempty"`
+	IsAllDay           bool                `json:"isAllDay,omitempty"`
+	IsCancelled        bool                `json:"isCancelled,omitempty"`
+	ResponseStatus     *responseStatus     `json:"responseStatus,omitempty"`
+	Sensitivity        string              `json:"sensitivity,omitempty"`
+	ShowAs             string              `json:"showAs,omitempty"`
+	Categories         []string            `json:"categories,omitempty"`
+	IsReminderOn       bool                `json:"isReminderOn"`
+	OnlineMeeting      *onlineMeeting      `json:"onlineMeeting,omitempty"`
+	OnlineProvider     string              `json:"onlineMeetingProvider,omitempty"`
+	ReminderMinutes    int                 `json:"reminderMinutesBeforeStart"`
+	WebLink            string              `json:"webLink,omitempty"`
+	LastModified       string              `json:"lastModifiedDateTime,omitempty"`
+	ExtendedProperties []*extendedProperty `json:"singleValueExtendedProperties,omitempty"`
+	Removed            *struct{}           `json:"@removed,omitempty"`
+}
+
+type itemBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type dateTimeTimeZone struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type location struct {
+	DisplayName string `json:"displayName"`
+}
+
+type recipient struct {
+	EmailAddress struct {
+		Name    string `json:"name,omitempty"`
+		Address string `json:"address"`
+	} `json:"emailAddress"`
+	Type   string          `json:"type,omitempty"`   // Attendees only: "required", "optional" or "resource"
+	Status *responseStatus `json:"status,omitempty"` // Attendees only
+}
+
+type responseStatus struct {
+	Response string `json:"response"`
+}
+
+type onlineMeeting struct {
+	JoinURL string `json:"joinUrl"`
+}
+
+type extendedProperty struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+// property returns the value of the single-value extended property with the given ID.
+func (e *graphEvent) property(id string) string {
+	for _, p := range e.ExtendedProperties {
+		if strings.EqualFold(p.ID, id) {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// toInternalEvent converts a Graph event to the internal Event model, returning nil for all-day
+// events and events without valid times.
+func (c *Client) toInternalEvent(item *graphEvent, calendarID string) *models.Event {
+	if item.IsAllDay || item.Start == nil || item.End == nil {
+		return nil
+	}
+	startTime, err := item.Start.time()
+	if err != nil {
+		c.logger.Warn("Skipping Outlook event with invalid start time", "eventID", item.ID, "error", err)
+		return nil
+	}
+	endTime, err := item.End.time()
+	if err != nil {
+		c.logger.Warn("Skipping Outlook event with invalid end time", "eventID", item.ID, "error", err)
+		return nil
+	}
+	updated, _ := time.Parse(time.RFC3339, item.LastModified)
+
+	event := &models.Event{
+		ID:         item.ID,
+		Title:      item.Subject,
+		StartTime:  startTime,
+		EndTime:    endTime,
+		UID:        item.ICalUID,
+		Updated:    updated,
+		Source:     SourceName(calendarID),
+		Account:    c.account,
+		CalendarID: calendarID,
+	}
+	if item.Body != nil {
+		event.Description = strings.TrimSpace(item.Body.Content)
+	}
+	if item.Location != nil {
+		event.Location = item.Location.DisplayName
+	}
+	if item.Organizer != nil {
+		event.Organizer = strings.ToLower(item.Organizer.EmailAddress.Address)
+		event.OrganizerName = item.Organizer.EmailAddress.Name
+	}
+	for _, a := range item.Attendees {
+		attendee := models.Attendee{
+			Email:    strings.ToLower(a.EmailAddress.Address),
+			Name:     a.EmailAddress.Name,
+			Optional: a.Type == "optional",
+			Resource: a.Type == "resource",
+		}
+		if a.Status != nil {
+			attendee.ResponseStatus = responseStatuses[a.Status.Response]
+		}
+		event.Attendees = append(event.Attendees, attendee)
+	}
+	if item.ResponseStatus != nil && len(item.Attendees) > 0 {
+		event.ResponseStatus = responseStatuses[item.ResponseStatus.Response]
+	}
+	event.Visibility = visibilities[item.Sensitivity]
+	event.Categories, event.Link = item.Categories, item.WebLink
+	if item.OnlineMeeting != nil && item.OnlineMeeting.JoinURL != "" {
+		event.Conference = models.Conference{Name: meetingProviders[item.OnlineProvider], URL: item.OnlineMeeting.JoinURL}
+	}
+	if item.IsReminderOn {
+		event.Reminders = []int{item.ReminderMinutes}
+	}
+	event.EventType = "default"
+	switch item.ShowAs {
+	case "oof":
+		event.EventType = "outOfOffice"
+	case "free":
+		event.Transparency = "transparent"
+	case "tentative":
+		event.Status = "tentative"
+	}
+	return event
+}
+
+// responseStatuses maps Graph responses to the invitation to the response statuses of Google Calendar.
+var responseStatuses = map[string]string{
+	"organizer":           "accepted",
+	"accepted":            "accepted",
+	"tentativelyAccepted": "tentative",
+	"declined":            "declined",
+	"notResponded":        "needsAction",
+}
+
+// meetingProviders maps the online meeting providers of Graph to their names.
+var meetingProviders = map[string]string{
+	"teamsForBusiness": "Microsoft Teams",
+	"skypeForBusiness": "Skype for Business",
+	"skypeForConsumer": "Skype",
+}
+
+// visibilities maps Graph sensitivities to the visibilities of Google Calendar.
+var visibilities = map[string]string{
+	"normal":       "default",
+	"personal":     "private",
+	"private":      "private",
+	"confidential": "confidential",
+}
+
+// toGraphEvent converts the writable fields of an internal Event to a Graph event.
+// Attendees are not copied to avoid sending invitations.
+func toGraphEvent(event *models.Event) *graphEvent {
+	item := &graphEvent{
+		Subject:     event.Title,
+		Body:        body(event),
+		Start:       &dateTimeTimeZone{DateTime: event.StartTime.UTC().Format(graphTimeLayout), TimeZone: "UTC"},
+		End:         &dateTimeTimeZone{DateTime: event.EndTime.UTC().Format(graphTimeLayout), TimeZone: "UTC"},
+		Location:    &location{DisplayName: event.Location},
+		Categories:  event.Categories,
+		ShowAs:      showAs(event),
+		Sensitivity: sensitivity(event.Visibility),
+	}
+	// Outlook events have a single reminder, so the one that alerts first is kept.
+	if len(event.Reminders) > 0 {
+		item.IsReminderOn, item.ReminderMinutes = true, slices.Max(event.Reminders)
+	}
+	return item
+}
+
+// body returns the body of an event, in HTML if its description has an HTML version.
+func body(event *models.Event) *itemBody {
+	if event.DescriptionHTML != "" {
+		return &itemBody{ContentType: "html", Content: event.DescriptionHTML}
+	}
+	return &itemBody{ContentType: "text", Content: event.Description}
+}
+
+// showAs returns how Outlook shows the time of an event.
+func showAs(event *models.Event) string {
+	switch {
+	case event.Transparency == "transparent":
+		return "free"
+	case event.Status == "tentative":
+		return "tentative"
+	}
+	return "busy"
+}
+
+// sensitivity returns the Graph sensitivity of an event with the given visibility.
+func sensitivity(visibility string) string {
+	if visibility == "private" || visibility == "confidential" {
+		return visibility
+	}
+	return "normal"
+}
+
+// time parses a Graph date and time. Times are requested in UTC, other time zones are only
+// understood if they are IANA names.
+func (d *dateTimeTimeZone) time() (time.Time, error) {
+	loc := time.UTC
+	if d.TimeZone != "" && d.TimeZone != "UTC" {
+		var err error
+		if loc, err = time.LoadLocation(d.TimeZone); err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone '%s'", d.TimeZone)
+		}
+	}
+	t, err := time.ParseInLocation(graphTimeLayout, d.DateTime, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// SourceName returns the models.Event Source value used for events of the given calendar.
+func SourceName(calendarID string) string {
+	return fmt.Sprintf("outlook-%s", calendarID)
+}
+
+// OAuthConfig returns the OAuth2 config of the device code flow for the given Azure app registration.
+// If write is set, access to create and modify events is requested in addition to read access.
+// An empty tenant uses DefaultTenant.
+func OAuthConfig(clientID, tenant string, write bool) *oauth2.Config {
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	scope := "Calendars.Read"
+	if write {
+		scope = "Calendars.ReadWrite"
+	}
+	return &oauth2.Config{
+		ClientID: clientID,
+		Scopes:   []string{"offline_access", scope},
+		Endpoint: microsoft.AzureADEndpoint(tenant),
+	}
+}
+
+// TokenFile returns the file the token of the named account is saved in.
+func TokenFile(accountName string) string {
+	return fmt.Sprintf("outlook-token-%s.json", accountName)
+}
+
+// SaveToken saves a token to a file path.
+func SaveToken(path string, token *oauth2.Token) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create token file: %w", err)
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(token)
+}
+
+// tokenFromFile retrieves a token from a local file.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tok := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(tok)
+	return tok, err
+}
+
+// GetTokenAccounts returns the names of the Outlook accounts that have a saved token.
+func GetTokenAccounts() ([]string, error) {
+	files, err := os.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []string
+	for _, file := range files {
+		if name, ok := strings.CutPrefix(file.Name(), "outlook-token-"); ok && strings.HasSuffix(name, ".json") {
+			accounts = append(accounts, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	return accounts, nil
+}
+
+// savingTokenSource saves the token again whenever it was refreshed with a new refresh token.
+type savingTokenSource struct {
+	base   oauth2.TokenSource
+	file   string
+	logger *slog.Logger
+
+	mu           sync.Mutex
+	refreshToken string
+}
+
+// Token implements oauth2.TokenSource.
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.RefreshToken != "" && token.RefreshToken != s.refreshToken {
+		if err := SaveToken(s.file, token); err != nil {
+			s.logger.Warn("Could not save refreshed Outlook token", "file", s.file, "error", err)
+		} else {
+			s.refreshToken = token.RefreshToken
+		}
+	}
+	return token, nil
+}
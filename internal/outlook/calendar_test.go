@@ -0,0 +1,121 @@
+package outlook
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+// timedEvent returns a Graph event with the given subject that starts the given number of hours from now.
+func timedEvent(subject string, hours int) *graphEvent {
+	start := time.Now().UTC().Truncate(time.Hour).Add(time.Duration(hours) * time.Hour)
+	return &graphEvent{
+		Subject: subject,
+		Start:   &dateTimeTimeZone{DateTime: start.Format(graphTimeLayout), TimeZone: "UTC"},
+		End:     &dateTimeTimeZone{DateTime: start.Add(time.Hour).Format(graphTimeLayout), TimeZone: "UTC"},
+	}
+}
+
+// fetchTitles returns the sorted titles of the events GetEvents returns for the next week.
+func fetchTitles(t *testing.T, c *Client) []string {
+	t.Helper()
+	now := time.Now()
+	events, err := c.GetEvents(context.Background(), PrimaryCalendar, now, now.Add(7*24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetEvents: %v", err)
+	}
+	var titles []string
+	for _, event := range events {
+		titles = append(titles, event.Title)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func TestGetEventsFollowsDeltaPages(t *testing.T) {
+	f, c := newFakeGraph(t)
+	for i, subject := range []string{"Standup", "Review", "Planning", "Retro", "Lunch"} {
+		f.add(timedEvent(subject, i+1))
+	}
+
+	got := fetchTitles(t, c)
+	if want := []string{"Lunch", "Planning", "Retro", "Review", "Standup"}; !slices.Equal(got, want) {
+		t.Fatalf("first fetch = %v, want %v", got, want)
+	}
+	if n := f.count("GET /me/calendar/calendarView/delta"); n != 3 {
+		t.Errorf("first fetch made %d delta requests, want 3 pages", n)
+	}
+}
+
+func TestGetEventsAppliesChangesFromDeltaLink(t *testing.T) {
+	f, c := newFakeGraph(t)
+	standup := f.add(timedEvent("Standup", 1))
+	f.add(timedEvent("Review", 2))
+	fetchTitles(t, c)
+
+	f.remove(standup)
+	f.add(timedEvent("Planning", 3))
+	cancelled := timedEvent("Cancelled", 4)
+	cancelled.IsCancelled = true
+	f.add(cancelled)
+
+	got := fetchTitles(t, c)
+	if want := []string{"Planning", "Review"}; !slices.Equal(got, want) {
+		t.Fatalf("second fetch = %v, want %v", got, want)
+	}
+	if n := f.count("GET /me/calendar/calendarView/delta deltatoken="); n != 1 {
+		t.Errorf("second fetch made %d requests with a delta link, want 1", n)
+	}
+}
+
+func TestGetEventsRefetchesExpiredDeltaLink(t *testing.T) {
+	f, c := newFakeGraph(t)
+	f.add(timedEvent("Standup", 1))
+	fetchTitles(t, c)
+
+	f.add(timedEvent("Review", 2))
+	f.gone = true
+	got := fetchTitles(t, c)
+	if want := []string{"Review", "Standup"}; !slices.Equal(got, want) {
+		t.Fatalf("fetch after expired delta link = %v, want %v", got, want)
+	}
+}
+
+func TestGetEventsSkipsAllDayAndOutOfWindowEvents(t *testing.T) {
+	f, c := newFakeGraph(t)
+	f.add(timedEvent("Standup", 1))
+	allDay := timedEvent("Holiday", 24)
+	allDay.IsAllDay = true
+	f.add(allDay)
+	f.add(timedEvent("Next month", 24*30))
+
+	got := fetchTitles(t, c)
+	if want := []string{"Standup"}; !slices.Equal(got, want) {
+		t.Fatalf("fetch = %v, want %v", got, want)
+	}
+}
+
+func TestGetEventsReadsPipelineMarkers(t *testing.T) {
+	f, c := newFakeGraph(t)
+	f.add(timedEvent("Standup", 1))
+	written := timedEvent("Copied", 2)
+	written.ExtendedProperties = []*extendedProperty{{ID: propSourceID, Value: "google"}, {ID: propPipelineID, Value: "work"}}
+	f.add(written)
+
+	now := time.Now()
+	events, err := c.GetEvents(context.Background(), PrimaryCalendar, now, now.Add(7*24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetEvents: %v", err)
+	}
+	for _, event := range events {
+		want := ""
+		if event.Title == "Copied" {
+			want = "work"
+		}
+		if event.Pipeline != want {
+			t.Errorf("pipeline of %q = %q, want %q", event.Title, event.Pipeline, want)
+		}
+	}
+}
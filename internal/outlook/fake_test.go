@@ -0,0 +1,208 @@
+package outlook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGraph is an in-memory Graph server holding the events of a single calendar. Delta queries
+// return the events in pages of pageSize, and then the changes made since the previous query.
+type fakeGraph struct {
+	t        *testing.T
+	srv      *httptest.Server
+	pageSize int
+
+	mu       sync.Mutex
+	events   map[string]*graphEvent
+	changes  []*graphEvent // Changes the next delta query returns
+	nextID   int
+	gone     bool     // The next delta query with a delta link fails with 410 Gone
+	requests []string // Method and path of every request, with the delta token if there is one
+}
+
+// newFakeGraph starts a fake Graph server and returns it with a client pointed at it.
+func newFakeGraph(t *testing.T) (*fakeGraph, *Client) {
+	f := &fakeGraph{t: t, pageSize: 2, events: make(map[string]*graphEvent)}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return f, New(logger, f.srv.Client(), f.srv.URL, "work")
+}
+
+// add stores an event as if it was created in Outlook, and returns its ID.
+func (f *fakeGraph) add(item *graphEvent) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.store(item)
+}
+
+// remove deletes an event as if it was deleted in Outlook.
+func (f *fakeGraph) remove(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.events, id)
+	f.changes = append(f.changes, &graphEvent{ID: id, Removed: &struct{}{}})
+}
+
+// store saves an event, assigning it an ID, iCalendar UID and ETag if it has none. f.mu must be held.
+func (f *fakeGraph) store(item *graphEvent) string {
+	if item.ID == "" {
+		f.nextID++
+		item.ID = fmt.Sprintf("id%d", f.nextID)
+	}
+	if item.ICalUID == "" {
+		item.ICalUID = "outlook-" + item.ID
+	}
+	item.ETag = fmt.Sprintf(`W/"%s-%d"`, item.ID, len(f.requests))
+	f.events[item.ID] = item
+	f.changes = append(f.changes, item)
+	return item.ID
+}
+
+// count returns how many requests matched the given prefix, e.g. "GET /me/calendar/calendarView/delta".
+func (f *fakeGraph) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+var (
+	uidFilter      = regexp.MustCompile(`^singleValueExtendedProperties/Any\(ep: ep/id eq '(.+)' and ep/value eq '(.*)'\)$`)
+	icalUIDFilter  = regexp.MustCompile(`^iCalUId eq '(.*)'$`)
+	markerFilter   = regexp.MustCompile(`^singleValueExtendedProperties/Any\(ep: ep/id eq '(.+)'\)$`)
+	eventPath      = regexp.MustCompile(`^/me/events/([^/]+)$`)
+	calendarEvents = regexp.MustCompile(`^/me/calendar/events$`)
+)
+
+func (f *fakeGraph) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	request := r.Method + " " + r.URL.Path
+	if token := r.URL.Query().Get("deltatoken"); token != "" {
+		request += " deltatoken=" + token
+	}
+	f.requests = append(f.requests, request)
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/me/calendar/calendarView/delta":
+		f.serveDelta(w, r)
+	case r.Method == http.MethodGet && calendarEvents.MatchString(r.URL.Path):
+		f.serveList(w, r.URL.Query().Get("$filter"))
+	case r.Method == http.MethodPost && calendarEvents.MatchString(r.URL.Path):
+		item := &graphEvent{}
+		if err := json.NewDecoder(r.Body).Decode(item); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.store(item)
+		w.WriteHeader(http.StatusCreated)
+		f.write(w, item)
+	case eventPath.MatchString(r.URL.Path):
+		id := eventPath.FindStringSubmatch(r.URL.Path)[1]
+		item, ok := f.events[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			f.write(w, map[string]any{"error": map[string]string{"code": "ErrorItemNotFound", "message": "not found"}})
+			return
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			// Properties left out of the request keep their value.
+			copied := *item
+			patched := &copied
+			if err := json.NewDecoder(r.Body).Decode(patched); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			patched.ID, patched.ICalUID = item.ID, item.ICalUID
+			f.store(patched)
+			f.write(w, patched)
+		case http.MethodDelete:
+			delete(f.events, id)
+			f.changes = append(f.changes, &graphEvent{ID: id, Removed: &struct{}{}})
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// serveDelta answers a calendarView delta query: every event in pages for a new query, and the
+// changes since the last query for a delta link.
+func (f *fakeGraph) serveDelta(w http.ResponseWriter, r *http.Request) {
+	next := f.srv.URL + "/me/calendar/calendarView/delta?"
+	if r.URL.Query().Has("deltatoken") {
+		if f.gone {
+			f.gone = false
+			w.WriteHeader(http.StatusGone)
+			f.write(w, map[string]any{"error": map[string]string{"code": "SyncStateNotFound", "message": "expired"}})
+			return
+		}
+		f.write(w, map[string]any{"value": f.changes, "@odata.deltaLink": next + "deltatoken=" + strconv.Itoa(len(f.requests))})
+		f.changes = nil
+		return
+	}
+
+	var all []*graphEvent
+	for _, item := range f.events {
+		all = append(all, item)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	skip, _ := strconv.Atoi(r.URL.Query().Get("skiptoken"))
+	end := min(skip+f.pageSize, len(all))
+	page := map[string]any{"value": all[skip:end]}
+	if end < len(all) {
+		page["@odata.nextLink"] = next + "skiptoken=" + strconv.Itoa(end)
+	} else {
+		page["@odata.deltaLink"] = next + "deltatoken=" + strconv.Itoa(len(f.requests))
+		f.changes = nil
+	}
+	f.write(w, page)
+}
+
+// serveList answers the event queries of the writer and of the pipeline markers.
+func (f *fakeGraph) serveList(w http.ResponseWriter, filter string) {
+	var found []*graphEvent
+	for _, item := range f.events {
+		if m := uidFilter.FindStringSubmatch(filter); m != nil {
+			if item.property(m[1]) == strings.ReplaceAll(m[2], "''", "'") {
+				found = append(found, item)
+			}
+		} else if m := icalUIDFilter.FindStringSubmatch(filter); m != nil {
+			if item.ICalUID == strings.ReplaceAll(m[1], "''", "'") {
+				found = append(found, item)
+			}
+		} else if m := markerFilter.FindStringSubmatch(filter); m != nil {
+			if value := item.property(m[1]); value != "" {
+				found = append(found, &graphEvent{ID: item.ID, ExtendedProperties: []*extendedProperty{{ID: m[1], Value: value}}})
+			}
+		} else {
+			f.t.Errorf("unexpected filter %q", filter)
+		}
+	}
+	f.write(w, map[string]any{"value": found})
+}
+
+func (f *fakeGraph) write(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		f.t.Errorf("failed to encode response: %v", err)
+	}
+}
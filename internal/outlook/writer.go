@@ -0,0 +1,153 @@
+package outlook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syncal/internal/models"
+)
+
+// Extended properties that mark events created by syncal. Graph doesn't let clients choose the
+// iCalendar UID of new events, so the UID of the source event is kept in an extended property too.
+const (
+	// propSet is PS_PUBLIC_STRINGS, the property set for named properties of other applications.
+	propSet = "{00020329-0000-0000-C000-000000000046}"
+
+	propSourceID   = "String " + propSet + " Name syncalSource"
+	propPipelineID = "String " + propSet + " Name syncalPipeline"
+	propUIDID      = "String " + propSet + " Name syncalUID"
+)
+
+// ErrForeignObject is returned when an operation would modify an event that syncal did not create.
+var ErrForeignObject = errors.New("outlook event is not managed by syncal")
+
+// CalendarWriter writes events to a single Outlook calendar, identifying them by the UID of the source event.
+type CalendarWriter struct {
+	client     *Client
+	calendarID string
+
+	// foreignCollisions counts UID collisions with events that syncal does not own.
+	foreignCollisions atomic.Int64
+}
+
+// Writer returns a CalendarWriter for the given calendar of the client's account.
+// The account must have been authorized with the 'auth --provider outlook --write' command.
+func (c *Client) Writer(calendarID string) *CalendarWriter {
+	return &CalendarWriter{client: c, calendarID: calendarID}
+}
+
+// SyncEvent creates or updates the event with the same UID in the calendar and returns the ETag of the written event.
+// The owned flag tells whether the sync state already maps this event to the target event.
+// If it doesn't, an existing event with the same iCalendar UID is only overwritten when syncal created it;
+// otherwise ErrForeignObject is returned.
+func (w *CalendarWriter) SyncEvent(ctx context.Context, event *models.Event, owned bool) (string, error) {
+	c := w.client
+	c.logger.Debug("Syncing event to Outlook", "eventTitle", event.Title, "uid", event.UID)
+
+	existing, managed, err := w.find(ctx, event.UID)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil && !managed && !owned {
+		w.reportForeignCollision(event.UID, "overwrite")
+		return "", fmt.Errorf("refusing to overwrite event %s: %w", event.UID, ErrForeignObject)
+	}
+
+	ge := toGraphEvent(event)
+	ge.ExtendedProperties = []*extendedProperty{
+		{ID: propSourceID, Value: event.Source},
+		{ID: propPipelineID, Value: event.Pipeline},
+		{ID: propUIDID, Value: event.UID},
+	}
+	var written graphEvent
+	if existing == nil {
+		err = c.do(ctx, http.MethodPost, calendarPath(w.calendarID)+"/events", ge, &written)
+	} else {
+		err = c.do(ctx, http.MethodPatch, "/me/events/"+url.PathEscape(existing.ID), ge, &written)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write event: %w", err)
+	}
+
+	c.logger.Info("Successfully synced event to Outlook", "eventTitle", event.Title, "calendarID", w.calendarID)
+	return written.ETag, nil
+}
+
+// DeleteEvent removes the event with the given UID from the calendar.
+// Like SyncEvent, it refuses to delete events that syncal does not own.
+// Deleting an event that no longer exists is not an error.
+func (w *CalendarWriter) DeleteEvent(ctx context.Context, uid string, owned bool) error {
+	c := w.client
+	existing, managed, err := w.find(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		c.logger.Debug("Event already absent from Outlook, nothing to delete.", "uid", uid)
+		return nil
+	}
+	if !managed && !owned {
+		w.reportForeignCollision(uid, "delete")
+		return fmt.Errorf("refusing to delete event %s: %w", uid, ErrForeignObject)
+	}
+
+	err = c.do(ctx, http.MethodDelete, "/me/events/"+url.PathEscape(existing.ID), nil, nil)
+	if isStatus(err, http.StatusNotFound) {
+		c.logger.Debug("Event already absent from Outlook, nothing to delete.", "uid", uid)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+
+	c.logger.Info("Successfully deleted event from Outlook", "uid", uid, "calendarID", w.calendarID)
+	return nil
+}
+
+// ForeignCollisions returns how many UID collisions with foreign events were detected so far.
+func (w *CalendarWriter) ForeignCollisions() int64 {
+	return w.foreignCollisions.Load()
+}
+
+// CalendarKey returns the "account/calendarID" key of the calendar the writer writes to.
+func (w *CalendarWriter) CalendarKey() string {
+	return w.client.Account() + "/" + w.calendarID
+}
+
+// find returns the event written by syncal for the given UID, or else an event with that iCalendar UID,
+// and whether syncal created it. It returns nil if the calendar has neither.
+func (w *CalendarWriter) find(ctx context.Context, uid string) (*graphEvent, bool, error) {
+	filters := []string{
+		fmt.Sprintf("singleValueExtendedProperties/Any(ep: ep/id eq '%s' and ep/value eq '%s')", propUIDID, quote(uid)),
+		fmt.Sprintf("iCalUId eq '%s'", quote(uid)),
+	}
+	for i, filter := range filters {
+		query := url.Values{"$filter": {filter}, "$select": {"id"}, "$top": {"1"}}
+		var page struct {
+			Value []*graphEvent `json:"value"`
+		}
+		if err := w.client.do(ctx, http.MethodGet, calendarPath(w.calendarID)+"/events?"+query.Encode(), nil, &page); err != nil {
+			return nil, false, fmt.Errorf("failed to look up event %s: %w", uid, err)
+		}
+		if len(page.Value) > 0 {
+			return page.Value[0], i == 0, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// reportForeignCollision records and logs a UID collision with an event syncal doesn't own.
+func (w *CalendarWriter) reportForeignCollision(uid, operation string) {
+	total := w.foreignCollisions.Add(1)
+	w.client.logger.Warn("UID collision with an event not managed by syncal, leaving it untouched",
+		"uid", uid, "operation", operation, "calendarID", w.calendarID, "totalCollisions", total)
+}
+
+// quote escapes a string for use in an OData string literal.
+func quote(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
@@ -0,0 +1,145 @@
+package outlook
+
+import (
+	"context"
+	"errors"
+	"syncal/internal/models"
+	"testing"
+	"time"
+)
+
+// sourceEvent returns an event as read from a source calendar, with the given UID.
+func sourceEvent(uid, title string) *models.Event {
+	start := time.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+	return &models.Event{UID: uid, Title: title, StartTime: start, EndTime: start.Add(time.Hour), Source: "google", Pipeline: "work"}
+}
+
+func TestWriterCreatesThenUpdatesEvent(t *testing.T) {
+	f, c := newFakeGraph(t)
+	w := c.Writer(PrimaryCalendar)
+	ctx := context.Background()
+
+	etag, err := w.SyncEvent(ctx, sourceEvent("uid-1", "Standup"), false)
+	if err != nil {
+		t.Fatalf("SyncEvent (create): %v", err)
+	}
+	if etag == "" {
+		t.Error("SyncEvent returned no ETag")
+	}
+	if _, err := w.SyncEvent(ctx, sourceEvent("uid-1", "Standup (moved)"), true); err != nil {
+		t.Fatalf("SyncEvent (update): %v", err)
+	}
+
+	if n := f.count("POST "); n != 1 {
+		t.Errorf("%d events created, want 1", n)
+	}
+	if n := f.count("PATCH /me/events/"); n != 1 {
+		t.Errorf("%d events updated, want 1", n)
+	}
+	if len(f.events) != 1 {
+		t.Fatalf("calendar has %d events, want 1", len(f.events))
+	}
+	for _, item := range f.events {
+		if item.Subject != "Standup (moved)" {
+			t.Errorf("subject = %q, want the updated title", item.Subject)
+		}
+		if item.property(propUIDID) != "uid-1" || item.property(propPipelineID) != "work" || item.property(propSourceID) != "google" {
+			t.Errorf("event is missing the syncal markers: %+v", item.ExtendedProperties)
+		}
+	}
+}
+
+func TestWriterFindsEventByQuotedUID(t *testing.T) {
+	f, c := newFakeGraph(t)
+	w := c.Writer(PrimaryCalendar)
+	ctx := context.Background()
+
+	uid := "o'brien@example.com"
+	if _, err := w.SyncEvent(ctx, sourceEvent(uid, "Standup"), false); err != nil {
+		t.Fatalf("SyncEvent: %v", err)
+	}
+	if _, err := w.SyncEvent(ctx, sourceEvent(uid, "Standup"), false); err != nil {
+		t.Fatalf("SyncEvent of the event syncal wrote: %v", err)
+	}
+	if len(f.events) != 1 {
+		t.Errorf("calendar has %d events, want 1", len(f.events))
+	}
+}
+
+func TestWriterLeavesForeignEventAlone(t *testing.T) {
+	f, c := newFakeGraph(t)
+	w := c.Writer(PrimaryCalendar)
+	ctx := context.Background()
+	foreign := timedEvent("Someone else's", 1)
+	foreign.ICalUID = "shared-uid"
+	id := f.add(foreign)
+
+	_, err := w.SyncEvent(ctx, sourceEvent("shared-uid", "Copied"), false)
+	if !errors.Is(err, ErrForeignObject) {
+		t.Fatalf("SyncEvent over a foreign event = %v, want ErrForeignObject", err)
+	}
+	if err := w.DeleteEvent(ctx, "shared-uid", false); !errors.Is(err, ErrForeignObject) {
+		t.Fatalf("DeleteEvent of a foreign event = %v, want ErrForeignObject", err)
+	}
+	if got := w.ForeignCollisions(); got != 2 {
+		t.Errorf("ForeignCollisions = %d, want 2", got)
+	}
+	if f.events[id].Subject != "Someone else's" {
+		t.Errorf("foreign event was changed to %q", f.events[id].Subject)
+	}
+
+	// Events the sync state maps to the foreign event are the user's choice to overwrite.
+	if _, err := w.SyncEvent(ctx, sourceEvent("shared-uid", "Copied"), true); err != nil {
+		t.Fatalf("SyncEvent of an owned event: %v", err)
+	}
+	if f.events[id].Subject != "Copied" {
+		t.Errorf("owned event was not updated, subject %q", f.events[id].Subject)
+	}
+}
+
+func TestWriterDeletesEvent(t *testing.T) {
+	f, c := newFakeGraph(t)
+	w := c.Writer(PrimaryCalendar)
+	ctx := context.Background()
+
+	if _, err := w.SyncEvent(ctx, sourceEvent("uid-1", "Standup"), false); err != nil {
+		t.Fatalf("SyncEvent: %v", err)
+	}
+	if err := w.DeleteEvent(ctx, "uid-1", false); err != nil {
+		t.Fatalf("DeleteEvent: %v", err)
+	}
+	if len(f.events) != 0 {
+		t.Errorf("calendar still has %d events", len(f.events))
+	}
+	if err := w.DeleteEvent(ctx, "uid-1", true); err != nil {
+		t.Errorf("DeleteEvent of a missing event: %v", err)
+	}
+}
+
+func TestWriterSendsReminder(t *testing.T) {
+	f, c := newFakeGraph(t)
+	w := c.Writer(PrimaryCalendar)
+	ctx := context.Background()
+
+	event := sourceEvent("uid-1", "Standup")
+	event.Reminders = []int{5, 30}
+	if _, err := w.SyncEvent(ctx, event, false); err != nil {
+		t.Fatalf("SyncEvent: %v", err)
+	}
+	for _, item := range f.events {
+		if !item.IsReminderOn || item.ReminderMinutes != 30 {
+			t.Errorf("reminder on %v, %d minutes, want on, 30 minutes", item.IsReminderOn, item.ReminderMinutes)
+		}
+	}
+
+	// An event without reminders turns off the reminder Outlook would add.
+	event.Reminders = nil
+	if _, err := w.SyncEvent(ctx, event, true); err != nil {
+		t.Fatalf("SyncEvent (update): %v", err)
+	}
+	for _, item := range f.events {
+		if item.IsReminderOn {
+			t.Errorf("reminder still on, %d minutes", item.ReminderMinutes)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package server publishes the events of syncal pipelines as iCalendar subscriptions over HTTP.
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"syncal/internal/ics"
+	"syncal/internal/syncer"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// Server serves the events of every feed at /<pipeline>.ics.
+// Requests must carry the token in the "token" query parameter, since most calendar apps
+// can't send headers, or as a bearer token.
+type Server struct {
+	logger  *slog.Logger
+	token   string
+	refresh time.Duration
+	feeds   map[string]*cachedFeed
+}
+
+// cachedFeed is a feed and the last calendar generated from it.
+type cachedFeed struct {
+	feed *syncer.Feed
+
+	mu          sync.Mutex
+	body        []byte
+	etag        string
+	modified    time.Time // When the content last changed
+	refreshedAt time.Time // When the events were last fetched
+}
+
+// New creates a Server for the given feeds. The events of a feed are fetched again at most
+// once per refresh interval, however often clients poll.
+func New(logger *slog.Logger, token string, refresh time.Duration, feeds []*syncer.Feed) *Server {
+	s := &Server{logger: logger, token: token, refresh: refresh, feeds: make(map[string]*cachedFeed)}
+	for _, feed := range feeds {
+		s.feeds[feed.Pipeline()] = &cachedFeed{feed: feed}
+	}
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".ics")
+	cf := s.feeds[name]
+	if !ok || cf == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, etag, modified, err := s.calendar(r, cf)
+	if err != nil {
+		s.logger.Error("Could not generate calendar", "pipeline", name, "error", err)
+		http.Error(w, "calendar temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(s.refresh.Seconds())))
+	// ServeContent answers If-None-Match and If-Modified-Since with 304 Not Modified.
+	http.ServeContent(w, r, name+".ics", modified, bytes.NewReader(body))
+}
+
+// authorized reports whether the request carries the server token.
+func (s *Server) authorized(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// calendar returns the current calendar of a feed, fetching its events again if the last fetch
+// is older than the refresh interval. If the fetch fails, the last calendar is served.
+func (s *Server) calendar(r *http.Request, cf *cachedFeed) ([]byte, string, time.Time, error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if cf.body != nil && time.Since(cf.refreshedAt) < s.refresh {
+		return cf.body, cf.etag, cf.modified, nil
+	}
+
+	events, err := cf.feed.Events(r.Context())
+	if err != nil {
+		if cf.body == nil {
+			return nil, "", time.Time{}, err
+		}
+		s.logger.Warn("Could not refresh calendar, serving the last one", "pipeline", cf.feed.Pipeline(), "error", err)
+		return cf.body, cf.etag, cf.modified, nil
+	}
+	cf.refreshedAt = time.Now()
+
+	// The ETag is derived from the events rather than the encoded calendar, which has a new DTSTAMP every time.
+	data, err := json.Marshal(events)
+	if err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("failed to hash events: %w", err)
+	}
+	sum := sha1.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if etag == cf.etag {
+		return cf.body, cf.etag, cf.modified, nil
+	}
+
+	cal := ics.NewCalendar()
+	name := ical.NewProp("X-WR-CALNAME")
+	name.Value = cf.feed.Pipeline()
+	cal.Props.Set(name)
+	for _, event := range events {
+		cal.Children = append(cal.Children, ics.EncodeEvent(event, false))
+	}
+	var buf bytes.Buffer
+	if err := ics.EncodeCalendar(&buf, cal); err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("failed to encode calendar: %w", err)
+	}
+
+	cf.body, cf.etag, cf.modified = buf.Bytes(), etag, cf.refreshedAt
+	s.logger.Info("Calendar updated", "pipeline", cf.feed.Pipeline(), "events", len(events))
+	return cf.body, cf.etag, cf.modified, nil
+}
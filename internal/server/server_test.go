@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syncal/internal/models"
+	"syncal/internal/syncer"
+	"testing"
+	"time"
+)
+
+const testToken = "s3cret"
+
+// fakeSource is a source whose events and error can be changed between fetches.
+type fakeSource struct {
+	mu      sync.Mutex
+	events  []*models.Event
+	err     error
+	fetches int
+}
+
+// Fetch implements syncer.Source.
+func (f *fakeSource) Fetch(ctx context.Context, start, end time.Time) *syncer.FetchResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetches++
+	result := &syncer.FetchResult{Fetched: map[string]int{"work/primary": len(f.events)}}
+	if f.err != nil {
+		result.Errors = []error{f.err}
+		return result
+	}
+	for _, event := range f.events {
+		result.Events = append(result.Events, event.Clone())
+	}
+	return result
+}
+
+// set replaces the events of the source and the error its fetches fail with.
+func (f *fakeSource) set(err error, titles ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	start := time.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+	f.events, f.err = nil, err
+	for i, title := range titles {
+		f.events = append(f.events, &models.Event{
+			ID:         title,
+			UID:        strings.ToLower(title) + "@example.com",
+			Title:      title,
+			StartTime:  start.Add(time.Duration(i) * time.Hour),
+			EndTime:    start.Add(time.Duration(i)*time.Hour + 30*time.Minute),
+			Source:     "google",
+			Account:    "work",
+			CalendarID: "primary",
+		})
+	}
+}
+
+// newTestServer starts a server with a single feed for the "work" pipeline.
+func newTestServer(t *testing.T, refresh time.Duration) (*fakeSource, *httptest.Server) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := &fakeSource{}
+	source.set(nil, "Standup", "Review")
+	feed := syncer.NewFeed(logger, source, syncer.Options{Pipeline: "work"})
+	srv := httptest.NewServer(New(logger, testToken, refresh, []*syncer.Feed{feed}))
+	t.Cleanup(srv.Close)
+	return source, srv
+}
+
+// get requests the path from the server, with the given headers as name and value pairs.
+func get(t *testing.T, srv *httptest.Server, path string, headers ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return resp, string(body)
+}
+
+func TestServerChecksToken(t *testing.T) {
+	_, srv := newTestServer(t, time.Minute)
+	tests := []struct {
+		name    string
+		path    string
+		headers []string
+		want    int
+	}{
+		{"query token", "/work.ics?token=" + testToken, nil, http.StatusOK},
+		{"bearer token", "/work.ics", []string{"Authorization", "Bearer " + testToken}, http.StatusOK},
+		{"no token", "/work.ics", nil, http.StatusUnauthorized},
+		{"wrong query token", "/work.ics?token=guess", nil, http.StatusUnauthorized},
+		{"wrong bearer token", "/work.ics?token=" + testToken, []string{"Authorization", "Bearer guess"}, http.StatusUnauthorized},
+		{"basic auth", "/work.ics", []string{"Authorization", "Basic " + testToken}, http.StatusUnauthorized},
+		{"unknown pipeline", "/home.ics?token=" + testToken, nil, http.StatusNotFound},
+		{"not a calendar", "/work?token=" + testToken, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := get(t, srv, tt.path, tt.headers...)
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerServesCalendar(t *testing.T) {
+	_, srv := newTestServer(t, time.Minute)
+	resp, body := get(t, srv, "/work.ics?token="+testToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, want := range []string{"BEGIN:VCALENDAR", "X-WR-CALNAME:work", "SUMMARY:Standup", "UID:review@example.com"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("calendar doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestServerAnswersNotModified(t *testing.T) {
+	source, srv := newTestServer(t, 0)
+	resp, _ := get(t, srv, "/work.ics?token="+testToken)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("response has no ETag")
+	}
+
+	// The events are fetched again, but haven't changed.
+	resp, body := get(t, srv, "/work.ics?token="+testToken, "If-None-Match", etag)
+	if resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Errorf("status = %d with %d bytes, want 304 and no body", resp.StatusCode, len(body))
+	}
+
+	source.set(nil, "Standup", "Planning")
+	resp, body = get(t, srv, "/work.ics?token="+testToken, "If-None-Match", etag)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "SUMMARY:Planning") {
+		t.Fatalf("status = %d after a change, want 200 with the new event", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Error("ETag didn't change with the events")
+	}
+}
+
+func TestServerCachesCalendar(t *testing.T) {
+	source, srv := newTestServer(t, time.Hour)
+	for range 3 {
+		get(t, srv, "/work.ics?token="+testToken)
+	}
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if source.fetches != 1 {
+		t.Errorf("source fetched %d times, want once per refresh interval", source.fetches)
+	}
+}
+
+func TestServerKeepsLastCalendarOnFetchError(t *testing.T) {
+	source, srv := newTestServer(t, 0)
+	_, first := get(t, srv, "/work.ics?token="+testToken)
+
+	source.set(errors.New("calendar unavailable"))
+	resp, body := get(t, srv, "/work.ics?token="+testToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 with the last calendar", resp.StatusCode)
+	}
+	if body != first {
+		t.Errorf("served a different calendar after a failed fetch:\n%s", body)
+	}
+}
+
+func TestServerFailsWithoutCalendar(t *testing.T) {
+	source, srv := newTestServer(t, 0)
+	source.set(errors.New("calendar unavailable"))
+	resp, _ := get(t, srv, "/work.ics?token="+testToken)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 before any calendar was generated", resp.StatusCode)
+	}
+}
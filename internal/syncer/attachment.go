@@ -0,0 +1,30 @@
+package syncer
+
+import (
+	"strings"
+	"syncal/internal/models"
+)
+
+// attachmentsFooter returns the description of an event followed by the links to its attachments,
+// for calendars that ignore ATTACH properties. Links the description already has are left out.
+func attachmentsFooter(event *models.Event) string {
+	var lines []string
+	for _, a := range event.Attachments {
+		if strings.Contains(event.Description, a.URL) {
+			continue
+		}
+		if a.Title != "" {
+			lines = append(lines, "- "+a.Title+": "+a.URL)
+		} else {
+			lines = append(lines, "- "+a.URL)
+		}
+	}
+	if len(lines) == 0 {
+		return event.Description
+	}
+	footer := "Attachments:\n" + strings.Join(lines, "\n")
+	if event.Description == "" {
+		return footer
+	}
+	return event.Description + "\n\n" + footer
+}
@@ -0,0 +1,41 @@
+package syncer
+
+import (
+	"slices"
+	"strings"
+	"syncal/internal/models"
+)
+
+// Colors sets the colors of the events of a pipeline, and categories that go with them.
+// Colors are CSS color names, such as "orange" or "steelblue".
+type Colors struct {
+	// Color replaces the color of every event.
+	Color string
+	// Calendars replace the color of the events of a source calendar, given as a calendar ID or
+	// "account/calendarID". They take precedence over Color.
+	Calendars map[string]string
+	// Categories add a category to the events of a color, once the colors above are set. Without one,
+	// events whose color isn't replaced get the name of their color in the source, see models.Event.ColorName.
+	// An empty category adds none.
+	Categories map[string]string
+}
+
+// apply sets the color of an event and adds the category of its color.
+func (c Colors) apply(event *models.Event) {
+	original := event.Color
+	if color, ok := c.Calendars[event.Account+"/"+event.CalendarID]; ok {
+		event.Color = color
+	} else if color, ok := c.Calendars[event.CalendarID]; ok {
+		event.Color = color
+	} else if c.Color != "" {
+		event.Color = c.Color
+	}
+
+	category, ok := c.Categories[strings.ToLower(event.Color)]
+	if !ok && event.Color == original {
+		category = event.ColorName
+	}
+	if category != "" && !slices.Contains(event.Categories, category) {
+		event.Categories = append(event.Categories, category)
+	}
+}
@@ -0,0 +1,55 @@
+package syncer
+
+import (
+	"fmt"
+	"strings"
+	"syncal/internal/models"
+)
+
+// ConferenceLinks decides how the video call link of an event is written to the target.
+type ConferenceLinks string
+
+const (
+	// ConferenceURL writes the link as the URL and CONFERENCE properties of iCalendar targets.
+	ConferenceURL ConferenceLinks = "url"
+	// ConferenceDescription appends the link to the description instead, for calendar apps that only
+	// show text. It also reaches Google and Outlook targets, which can't be given a link otherwise.
+	ConferenceDescription ConferenceLinks = "description"
+	// ConferenceBoth writes the link as properties and in the description.
+	ConferenceBoth ConferenceLinks = "both"
+	// ConferenceNone leaves the link out.
+	ConferenceNone ConferenceLinks = "none"
+)
+
+// ParseConferenceLinks validates a conference link setting, defaulting to ConferenceURL.
+func ParseConferenceLinks(name string) (ConferenceLinks, error) {
+	switch links := ConferenceLinks(name); links {
+	case "":
+		return ConferenceURL, nil
+	case ConferenceURL, ConferenceDescription, ConferenceBoth, ConferenceNone:
+		return links, nil
+	default:
+		return "", fmt.Errorf("unknown conference link setting '%s'", name)
+	}
+}
+
+// inDescription reports whether the link is appended to the description.
+func (c ConferenceLinks) inDescription() bool {
+	return c == ConferenceDescription || c == ConferenceBoth
+}
+
+// conferenceFooter returns the description of an event followed by the link to its video call,
+// unless the description already has the link.
+func conferenceFooter(event *models.Event) string {
+	if strings.Contains(event.Description, event.Conference.URL) {
+		return event.Description
+	}
+	footer := "Join: " + event.Conference.URL
+	if event.Conference.Name != "" {
+		footer = fmt.Sprintf("Join %s: %s", event.Conference.Name, event.Conference.URL)
+	}
+	if event.Description == "" {
+		return footer
+	}
+	return event.Description + "\n\n" + footer
+}
@@ -0,0 +1,193 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// ConflictPolicy decides which side wins when an event changed in both Google and iCloud between two sync cycles.
+type ConflictPolicy string
+
+const (
+	// PolicySourceWins keeps the Google version. This is the default.
+	PolicySourceWins ConflictPolicy = "source-wins"
+	// PolicyTargetWins keeps the iCloud version.
+	PolicyTargetWins ConflictPolicy = "target-wins"
+	// PolicyNewestWins keeps the most recently modified version. An edit always wins over a deletion.
+	PolicyNewestWins ConflictPolicy = "newest-wins"
+	// PolicyManual leaves both sides untouched and quarantines the event until the conflict is resolved.
+	PolicyManual ConflictPolicy = "manual"
+)
+
+// Sides of a conflict.
+const (
+	sideSource = "source" // Google
+	sideTarget = "target" // iCloud
+)
+
+// Conflict is an event that changed on both sides since it was last synced.
+type Conflict struct {
+	Pipeline       string        `json:"pipeline"`
+	SourceID       string        `json:"sourceId"`
+	UID            string        `json:"uid"`
+	Title          string        `json:"title"`
+	DetectedAt     time.Time     `json:"detectedAt"`
+	SourceAction   Action        `json:"sourceAction"` // What happened in Google: update or delete
+	TargetAction   Action        `json:"targetAction"` // What happened in iCloud: update or delete
+	SourceModified time.Time     `json:"sourceModified,omitempty"`
+	TargetModified time.Time     `json:"targetModified,omitempty"`
+	Fields         []FieldChange `json:"fields,omitempty"` // Google value as Old, iCloud value as New
+
+	// Winner is the side that was kept, empty while the conflict is quarantined.
+	Winner string `json:"winner,omitempty"`
+	// Resolution is the side chosen with the conflicts command for a quarantined conflict.
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// ParseConflictPolicy validates a conflict policy name, defaulting to PolicySourceWins.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case "":
+		return PolicySourceWins, nil
+	case PolicySourceWins, PolicyTargetWins, PolicyNewestWins, PolicyManual:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy '%s'", name)
+	}
+}
+
+// newConflict describes a conflict on the given state entry, carrying over an earlier detection of it.
+func (s *Syncer) newConflict(ps *PipelineState, sourceID string, entry *SyncedEvent, sourceAction, targetAction Action) *Conflict {
+	c := &Conflict{
+		Pipeline:     s.pipeline,
+		SourceID:     sourceID,
+		UID:          entry.UID,
+		DetectedAt:   time.Now(),
+		SourceAction: sourceAction,
+		TargetAction: targetAction,
+	}
+	if entry.Snapshot != nil {
+		c.Title = entry.Snapshot.Title
+	}
+	if prev, ok := ps.Conflicts[sourceID]; ok {
+		c.DetectedAt = prev.DetectedAt
+		c.Resolution = prev.Resolution
+	}
+	return c
+}
+
+// resolveConflict decides which side of a conflict wins and records it in the plan.
+// It returns an empty side when the conflict has to wait for a manual resolution.
+func (s *Syncer) resolveConflict(plan *Plan, c *Conflict) string {
+	switch {
+	case c.Resolution != "":
+		c.Winner = c.Resolution
+	case s.conflictPolicy == PolicyTargetWins:
+		c.Winner = sideTarget
+	case s.conflictPolicy == PolicyNewestWins:
+		c.Winner = sideSource
+		switch {
+		case c.SourceAction == ActionDelete:
+			c.Winner = sideTarget
+		case c.TargetAction == ActionDelete:
+			c.Winner = sideSource
+		case c.TargetModified.After(c.SourceModified):
+			c.Winner = sideTarget
+		}
+	case s.conflictPolicy == PolicyManual:
+		c.Winner = ""
+	default:
+		c.Winner = sideSource
+	}
+
+	if c.Winner == "" {
+		s.logger.Warn("Event changed in both Google and iCloud, quarantined until resolved.", "title", c.Title, "uid", c.UID)
+	} else {
+		s.logger.Info("Event changed in both Google and iCloud, resolved.", "title", c.Title, "uid", c.UID, "kept", c.Winner)
+	}
+	plan.Conflicts = append(plan.Conflicts, c)
+	return c.Winner
+}
+
+// recordConflicts stores the quarantined conflicts of the plan in the sync state, replacing those of earlier cycles,
+// and reports them in the run.
+func (s *Syncer) recordConflicts(plan *Plan, run *RunRecord) {
+	run.Conflicts = len(plan.Conflicts)
+	if s.dryRun {
+		return
+	}
+
+	ps := s.state.pipeline(s.pipeline)
+	ps.Conflicts = make(map[string]*Conflict)
+	for _, c := range plan.Conflicts {
+		if c.Winner == "" {
+			ps.Conflicts[c.SourceID] = c
+		}
+	}
+	if len(ps.Conflicts) > 0 {
+		s.logger.Warn("Some events are quarantined because of conflicts, see 'syncal conflicts'.", "count", len(ps.Conflicts))
+	}
+}
+
+// ListConflicts returns the quarantined conflicts of all pipelines, oldest first.
+func ListConflicts() ([]*Conflict, error) {
+	state, err := loadState()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load sync state: %w", err)
+	}
+
+	var conflicts []*Conflict
+	for _, ps := range state.Pipelines {
+		for _, c := range ps.Conflicts {
+			conflicts = append(conflicts, c)
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].DetectedAt.Before(conflicts[j].DetectedAt)
+	})
+	return conflicts, nil
+}
+
+// ResolveConflict records which side to keep for a quarantined conflict, identified by its Google Event ID or UID.
+// The side is "source" (or "google") or "target" (or "icloud"). It is applied by the next sync cycle,
+// even when a sync that started before is still running.
+func ResolveConflict(key, side string) error {
+	switch side {
+	case sideSource, "google":
+		side = sideSource
+	case sideTarget, "icloud":
+		side = sideTarget
+	default:
+		return fmt.Errorf("unknown side '%s', expected 'source' (google) or 'target' (icloud)", side)
+	}
+
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := loadState()
+	if err != nil {
+		return fmt.Errorf("failed to load sync state: %w", err)
+	}
+
+	found := false
+	for _, ps := range state.Pipelines {
+		for _, c := range ps.Conflicts {
+			if c.SourceID == key || c.UID == key {
+				c.Resolution = side
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no quarantined conflict found for '%s'", key)
+	}
+	return writeState(state)
+}
@@ -0,0 +1,75 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"syncal/internal/filter"
+	"syncal/internal/models"
+	"time"
+)
+
+// Feed reads the events a pipeline would sync, without a target, so they can be published directly.
+type Feed struct {
+	logger          *slog.Logger
+	source          Source
+	pipeline        string
+	primaryTimeZone *time.Location
+	filter          *filter.Filter
+	rewriter        rewriter
+}
+
+// NewFeed creates a Feed of the events of source, selected the same way as by a syncer
+// with the same Pipeline, TimeZone, Filter, Transform, MarkTentative and Redaction options.
+// Other options are ignored.
+func NewFeed(logger *slog.Logger, source Source, opts Options) *Feed {
+	if opts.Pipeline == "" {
+		opts.Pipeline = DefaultPipeline
+	}
+	if opts.TimeZone == nil {
+		opts.TimeZone = time.UTC
+	}
+	return &Feed{
+		logger:          logger,
+		source:          source,
+		pipeline:        opts.Pipeline,
+		primaryTimeZone: opts.TimeZone,
+		filter:          opts.Filter,
+		rewriter:        newRewriter(opts),
+	}
+}
+
+// Pipeline returns the name of the pipeline the feed belongs to.
+func (f *Feed) Pipeline() string {
+	return f.pipeline
+}
+
+// Events returns the events in the sync window, sorted by start time.
+// Unlike a sync, it fails if any calendar of the source can't be fetched, since a partial feed
+// would make subscribers drop the events of that calendar.
+func (f *Feed) Events(ctx context.Context) ([]*models.Event, error) {
+	now := time.Now()
+	fetch := f.source.Fetch(ctx, now, now.Add(syncWindowDays*24*time.Hour))
+	if err := errors.Join(fetch.Errors...); err != nil {
+		return nil, err
+	}
+
+	events := selectEvents(fetch.Events, f.pipeline, f.primaryTimeZone, f.filter)
+	for i, event := range events {
+		event, _ = f.rewriter.rewrite(f.logger, event)
+		events[i] = event
+		if event.UID == "" {
+			event.UID = event.ID
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if !a.StartTime.Equal(b.StartTime) {
+			return a.StartTime.Before(b.StartTime)
+		}
+		return a.UID < b.UID
+	})
+	f.logger.Debug("Fetched feed events.", "pipeline", f.pipeline, "count", len(events))
+	return events, nil
+}
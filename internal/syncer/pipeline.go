@@ -0,0 +1,86 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"syncal/internal/models"
+	"time"
+)
+
+// Pipeline syncs the events of one source to one or more targets.
+// The source is fetched once per cycle, and every target is synced by a Syncer of its own, with its own
+// sync state and run history. A target that fails doesn't hold back the others, and the changes it
+// couldn't make are retried by its next cycle.
+type Pipeline struct {
+	logger  *slog.Logger
+	name    string
+	source  Source
+	syncers []*Syncer
+}
+
+// NewPipeline creates a Pipeline that reads the events of source once for all the given syncers,
+// one per target. The syncers are usually created with the same source.
+func NewPipeline(logger *slog.Logger, name string, source Source, syncers []*Syncer) *Pipeline {
+	return &Pipeline{logger: logger, name: name, source: source, syncers: syncers}
+}
+
+// Name returns the name of the pipeline.
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+// Sync performs a sync cycle for every target.
+// It returns the errors of the targets that failed, after syncing all the others.
+func (p *Pipeline) Sync(ctx context.Context) error {
+	source := p.fetch(ctx)
+	var errs []error
+	for _, s := range p.syncers {
+		if err := s.sync(ctx, source); err != nil {
+			p.logger.Error("Sync cycle of target failed", "pipeline", s.pipeline, "error", err)
+			errs = append(errs, fmt.Errorf("pipeline '%s': %w", s.pipeline, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Plan returns the plan of every target, in the order of the syncers.
+func (p *Pipeline) Plan(ctx context.Context) ([]*Plan, error) {
+	source := p.fetch(ctx)
+	var plans []*Plan
+	for _, s := range p.syncers {
+		plan, err := s.plan(ctx, source, &RunRecord{Fetched: make(map[string]int)})
+		if err != nil {
+			return nil, fmt.Errorf("pipeline '%s': %w", s.pipeline, err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// fetch reads the source for the sync window, for the syncers to share.
+// With a single target, the syncer reads the source itself.
+func (p *Pipeline) fetch(ctx context.Context) Source {
+	if len(p.syncers) == 1 {
+		return p.source
+	}
+	now := time.Now()
+	return &fetchedSource{result: p.source.Fetch(ctx, now, now.Add(syncWindowDays*24*time.Hour))}
+}
+
+// fetchedSource replays a fetch to every target of a pipeline. Each Fetch returns copies of the events,
+// since syncers modify the events they plan with.
+type fetchedSource struct {
+	result *FetchResult
+}
+
+// Fetch implements Source.
+func (f *fetchedSource) Fetch(ctx context.Context, start, end time.Time) *FetchResult {
+	result := *f.result
+	result.Events = make([]*models.Event, len(f.result.Events))
+	for i, event := range f.result.Events {
+		result.Events[i] = event.Clone()
+	}
+	return &result
+}
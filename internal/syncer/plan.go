@@ -0,0 +1,333 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"syncal/internal/filter"
+	"syncal/internal/icloud"
+	"syncal/internal/models"
+	"time"
+)
+
+// syncWindowDays is how far ahead events are fetched from the sources.
+const syncWindowDays = 7
+
+// Action is what a sync cycle will do with an event.
+type Action string
+
+const (
+	ActionCreate    Action = "create"
+	ActionUpdate    Action = "update"
+	ActionDelete    Action = "delete"
+	ActionUnchanged Action = "unchanged"
+)
+
+// Targets a change can be applied to.
+const (
+	TargetICloud  = "icloud"
+	TargetCalDAV  = "caldav"
+	TargetGoogle  = "google"
+	TargetICS     = "ics"
+	TargetOutlook = "outlook"
+)
+
+// targetNames are the names of the targets used in log messages.
+var targetNames = map[string]string{
+	TargetICloud:  "iCloud",
+	TargetCalDAV:  "CalDAV",
+	TargetGoogle:  "Google",
+	TargetICS:     "ICS files",
+	TargetOutlook: "Outlook",
+}
+
+// FieldChange describes a single field that differs from the last synced version of an event.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Change is a single planned operation on the target calendar.
+type Change struct {
+	Action   Action        `json:"action"`
+	Target   string        `json:"target"`
+	Pipeline string        `json:"pipeline"`
+	SourceID string        `json:"sourceId"`
+	UID      string        `json:"uid"`
+	Title    string        `json:"title"`
+	Start    time.Time     `json:"start"`
+	Fields   []FieldChange `json:"fields,omitempty"`
+
+	event    *models.Event       // The event to write, nil for deletions
+	redacted bool                // The event is written without its details
+	from     *icloud.RemoteEvent // The iCloud object the change was read from, for changes targeting Google
+}
+
+// Plan is the full set of changes a sync cycle would make.
+type Plan struct {
+	Pipeline  string      `json:"pipeline"`
+	Changes   []Change    `json:"changes"`
+	Conflicts []*Conflict `json:"conflicts,omitempty"`
+}
+
+// Count returns the number of changes with the given action.
+func (p *Plan) Count(action Action) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Plan fetches the source events and compares them with the sync state to work out
+// which events need to be created, updated or deleted in the target calendar.
+// It does not modify the state or the target.
+func (s *Syncer) Plan(ctx context.Context) (*Plan, error) {
+	return s.plan(ctx, s.source, &RunRecord{Fetched: make(map[string]int)})
+}
+
+// plan implements Plan with the events of the given source, recording what was fetched in run.
+func (s *Syncer) plan(ctx context.Context, source Source, run *RunRecord) (*Plan, error) {
+	ps := s.state.pipeline(s.pipeline)
+	if len(ps.Events) > 0 && ps.target() != s.target {
+		return nil, fmt.Errorf("pipeline '%s' was synced to %s, use another pipeline name to sync to %s", s.pipeline, ps.target(), s.target)
+	}
+	if calendar := s.writer.CalendarKey(); len(ps.Events) > 0 && ps.Calendar != "" && ps.Calendar != calendar {
+		return nil, fmt.Errorf("pipeline '%s' was synced to %s, use another pipeline name to sync to %s", s.pipeline, ps.Calendar, calendar)
+	}
+
+	now := time.Now()
+	windowEnd := now.Add(syncWindowDays * 24 * time.Hour)
+	fetch := source.Fetch(ctx, now, windowEnd)
+	for key, n := range fetch.Fetched {
+		run.Fetched[key] = n
+	}
+	for _, err := range fetch.Errors {
+		run.addError(err)
+	}
+	for _, account := range fetch.ReauthAccounts {
+		if !slices.Contains(run.ReauthAccounts, account) {
+			run.ReauthAccounts = append(run.ReauthAccounts, account)
+		}
+	}
+
+	s.logger.Info("Fetched all source events.", "count", len(fetch.Events))
+
+	plan := &Plan{Pipeline: s.pipeline}
+	synced := ps.Events
+	seen := make(map[string]bool)
+
+	selected := selectEvents(fetch.Events, s.pipeline, s.primaryTimeZone, s.filter)
+	if skipped := len(fetch.Events) - len(selected); skipped > 0 {
+		s.logger.Debug("Skipped source events.", "count", skipped)
+	}
+	for _, event := range selected {
+		seen[event.ID] = true
+		prev, exists := synced[event.ID]
+		redacted := false
+		// Events created in iCloud are written back to their original, which must keep its details.
+		if !exists || prev.Origin != OriginICloud {
+			event, redacted = s.rewriter.rewrite(s.logger, event)
+		}
+		change := Change{Target: s.target, Pipeline: s.pipeline, SourceID: event.ID, Title: event.Title, Start: event.StartTime, event: event, redacted: redacted}
+
+		switch {
+		case !exists:
+			// We use the source iCal UID to ensure consistency if we sync from another client.
+			if event.UID == "" {
+				s.logger.Warn("Source event has no UID, generating a new one.", "title", event.Title)
+				event.UID = icloud.GenerateUID()
+			}
+			change.Action = ActionCreate
+		case prev.Snapshot == nil:
+			// Synced before snapshots were recorded, so rewrite it once to be sure it is current.
+			event.UID = prev.UID
+			change.Action = ActionUpdate
+		default:
+			event.UID = prev.UID
+			change.Fields = diffEvents(prev.Snapshot, event)
+			change.Action = ActionUnchanged
+			if len(change.Fields) > 0 {
+				change.Action = ActionUpdate
+			}
+		}
+		change.UID = event.UID
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	for id, prev := range synced {
+		if seen[id] || prev.Snapshot == nil {
+			continue
+		}
+		// Only events that should still be in the fetched window have really disappeared.
+		if _, ok := fetch.Fetched[prev.Snapshot.Account+"/"+prev.Snapshot.CalendarID]; !ok || !inWindow(prev.Snapshot, now, windowEnd) {
+			continue
+		}
+		plan.Changes = append(plan.Changes, Change{
+			Action:   ActionDelete,
+			Target:   s.target,
+			Pipeline: s.pipeline,
+			SourceID: id,
+			UID:      prev.UID,
+			Title:    prev.Snapshot.Title,
+			Start:    prev.Snapshot.StartTime,
+		})
+	}
+
+	if s.twoWay {
+		if err := s.planReverse(ctx, plan, now, windowEnd); err != nil {
+			// Without the iCloud side, the Google side of the plan is still valid.
+			s.logger.Error("Could not plan changes from iCloud", "error", err)
+			run.addError(err)
+		}
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		a, b := plan.Changes[i], plan.Changes[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.Title < b.Title
+	})
+	return plan, nil
+}
+
+// selectEvents returns the fetched events a pipeline copies, stamped with the pipeline and
+// with their times in the primary time zone. Events the filter rejects are left out.
+func selectEvents(events []*models.Event, pipeline string, loc *time.Location, f *filter.Filter) []*models.Event {
+	var selected []*models.Event
+	seen := make(map[string]bool)
+	for _, event := range events {
+		// The same event can show up in several calendars or accounts.
+		if seen[event.ID] {
+			continue
+		}
+		// Copies written by another pipeline would bounce between calendars mirrored both ways.
+		if event.Pipeline != "" && event.Pipeline != pipeline {
+			continue
+		}
+		seen[event.ID] = true
+
+		event.Pipeline = pipeline
+
+		// Adjust times to the primary timezone
+		event.StartTime = event.StartTime.In(loc)
+		event.EndTime = event.EndTime.In(loc)
+
+		if !f.Match(event) {
+			continue
+		}
+		selected = append(selected, event)
+	}
+	return selected
+}
+
+// inWindow reports whether an event overlaps the sync window.
+func inWindow(event *models.Event, start, end time.Time) bool {
+	return event.EndTime.After(start) && event.StartTime.Before(end)
+}
+
+// formatAddress formats an email with its display name, e.g. "Jane Doe <jane@example.com>".
+func formatAddress(name, email string) string {
+	if name == "" {
+		return email
+	}
+	return fmt.Sprintf("%s <%s>", name, email)
+}
+
+// formatAttendees lists attendees for the changes of a plan, with their responses and roles,
+// e.g. "Jane Doe <jane@example.com> (accepted, optional)".
+func formatAttendees(attendees []models.Attendee) string {
+	var s []string
+	for _, a := range attendees {
+		var details []string
+		if a.ResponseStatus != "" {
+			details = append(details, a.ResponseStatus)
+		}
+		if a.Optional {
+			details = append(details, "optional")
+		}
+		if a.Resource {
+			details = append(details, "resource")
+		}
+		entry := formatAddress(a.Name, a.Email)
+		if len(details) > 0 {
+			entry += " (" + strings.Join(details, ", ") + ")"
+		}
+		s = append(s, entry)
+	}
+	return strings.Join(s, ", ")
+}
+
+// formatAttachments lists attachments for the changes of a plan, e.g. "Agenda <https://drive.google.com/…> (application/pdf)".
+func formatAttachments(attachments []models.Attachment) string {
+	var s []string
+	for _, a := range attachments {
+		entry := formatAddress(a.Title, a.URL)
+		if a.MimeType != "" {
+			entry += " (" + a.MimeType + ")"
+		}
+		s = append(s, entry)
+	}
+	return strings.Join(s, ", ")
+}
+
+// formatConference describes a video call for the changes of a plan, e.g. "Zoom Meeting <https://zoom.us/j/1>".
+func formatConference(c models.Conference) string {
+	return formatAddress(c.Name, c.URL)
+}
+
+// formatReminders lists reminders for the changes of a plan, e.g. "10m, 60m".
+func formatReminders(reminders []int) string {
+	var s []string
+	for _, minutes := range reminders {
+		s = append(s, fmt.Sprintf("%dm", minutes))
+	}
+	return strings.Join(s, ", ")
+}
+
+// diffEvents returns the fields that differ between the last synced version of an event and the current one.
+func diffEvents(old, cur *models.Event) []FieldChange {
+	var changes []FieldChange
+	add := func(field, o, n string) {
+		if o != n {
+			changes = append(changes, FieldChange{Field: field, Old: o, New: n})
+		}
+	}
+
+	add("title", old.Title, cur.Title)
+	add("description", old.Description, cur.Description)
+	add("html description", old.DescriptionHTML, cur.DescriptionHTML)
+	if !old.StartTime.Equal(cur.StartTime) {
+		add("start", old.StartTime.Format(time.RFC3339), cur.StartTime.Format(time.RFC3339))
+	}
+	if !old.EndTime.Equal(cur.EndTime) {
+		add("end", old.EndTime.Format(time.RFC3339), cur.EndTime.Format(time.RFC3339))
+	}
+	add("location", old.Location, cur.Location)
+	add("organizer", formatAddress(old.OrganizerName, old.Organizer), formatAddress(cur.OrganizerName, cur.Organizer))
+	if !slices.Equal(old.Attendees, cur.Attendees) {
+		add("attendees", formatAttendees(old.Attendees), formatAttendees(cur.Attendees))
+	}
+	add("conference", formatConference(old.Conference), formatConference(cur.Conference))
+	if !slices.Equal(old.Attachments, cur.Attachments) {
+		add("attachments", formatAttachments(old.Attachments), formatAttachments(cur.Attachments))
+	}
+	add("link", old.Link, cur.Link)
+	add("color", old.Color, cur.Color)
+	add("status", old.Status, cur.Status)
+	add("transparency", old.Transparency, cur.Transparency)
+	add("visibility", old.Visibility, cur.Visibility)
+	if !slices.Equal(old.Categories, cur.Categories) {
+		add("categories", strings.Join(old.Categories, ", "), strings.Join(cur.Categories, ", "))
+	}
+	if !slices.Equal(old.Reminders, cur.Reminders) {
+		add("reminders", formatReminders(old.Reminders), formatReminders(cur.Reminders))
+	}
+	return changes
+}
@@ -0,0 +1,115 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+)
+
+// PurgeItem is a syncal-managed event in the target calendar scheduled for removal.
+type PurgeItem struct {
+	Pipeline string
+	UID      string
+	Title    string
+	sourceID string // ID of the source event in the sync state, empty when the event is only known by its provenance marker
+}
+
+// PurgeCandidates lists the events of the syncer's pipeline that a purge would delete from its target.
+// Events are taken from the sync state and, for iCloud and CalDAV targets, from the provenance markers
+// found in the calendar.
+func (s *Syncer) PurgeCandidates(ctx context.Context) ([]PurgeItem, error) {
+	ps := s.state.pipeline(s.pipeline)
+	if len(ps.Events) > 0 && ps.target() != s.target {
+		return nil, fmt.Errorf("pipeline '%s' was synced to %s, not %s", s.pipeline, ps.target(), s.target)
+	}
+	// The events of the state are only in the calendar they were synced to.
+	if calendar := s.writer.CalendarKey(); len(ps.Events) > 0 && ps.Calendar != "" && ps.Calendar != calendar {
+		return nil, fmt.Errorf("pipeline '%s' was synced to %s, not %s", s.pipeline, ps.Calendar, calendar)
+	}
+
+	var items []PurgeItem
+	index := make(map[string]int) // UID -> position in items
+	for id, synced := range ps.Events {
+		// Events created in iCloud are the user's own, syncal only copied them to Google.
+		if synced.Origin == OriginICloud {
+			continue
+		}
+		index[synced.UID] = len(items)
+		items = append(items, PurgeItem{Pipeline: s.pipeline, UID: synced.UID, Title: titleOf(synced), sourceID: id})
+	}
+
+	if s.icloudClient == nil {
+		return items, nil
+	}
+	managed, err := s.icloudClient.ListManagedEvents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list syncal events in %s: %w", s.target, err)
+	}
+	for _, m := range managed {
+		// Events written before pipelines existed carry no pipeline and belong to the default one.
+		if m.Pipeline != s.pipeline && (m.Pipeline != "" || s.pipeline != DefaultPipeline) {
+			continue
+		}
+		if i, ok := index[m.UID]; ok {
+			items[i].Title = m.Title
+			continue
+		}
+		index[m.UID] = len(items)
+		items = append(items, PurgeItem{Pipeline: s.pipeline, UID: m.UID, Title: m.Title})
+	}
+	return items, nil
+}
+
+// titleOf returns the title of a synced event, if its snapshot has one.
+func titleOf(synced *SyncedEvent) string {
+	if synced.Snapshot == nil {
+		return ""
+	}
+	return synced.Snapshot.Title
+}
+
+// Purge deletes the given events from the target and forgets them in the sync state.
+// It returns the number of events deleted.
+func (s *Syncer) Purge(ctx context.Context, items []PurgeItem) (int, error) {
+	name := targetNames[s.target]
+	if !s.dryRun {
+		// Other targets may have been purged and saved since the state was loaded.
+		if err := s.reloadState(); err != nil {
+			return 0, err
+		}
+	}
+
+	var deleted, failed int
+	for _, item := range items {
+		if s.dryRun {
+			s.logger.Info(fmt.Sprintf("[DRY RUN] Would delete event from %s", name), "uid", item.UID, "title", item.Title, "pipeline", s.pipeline)
+			continue
+		}
+
+		err := s.writer.DeleteEvent(ctx, item.UID, item.sourceID != "")
+		if isForeignObject(err) {
+			s.logger.Warn("Skipping event not managed by syncal.", "uid", item.UID, "target", s.target)
+			continue
+		}
+		if err != nil {
+			s.logger.Error("Failed to delete event", "uid", item.UID, "target", s.target, "error", err)
+			failed++
+			continue
+		}
+
+		if item.sourceID != "" {
+			delete(s.state.pipeline(s.pipeline).Events, item.sourceID)
+		}
+		deleted++
+	}
+
+	if s.dryRun {
+		return 0, nil
+	}
+	if err := s.saveState(); err != nil {
+		return deleted, fmt.Errorf("failed to save sync state: %w", err)
+	}
+	if failed > 0 {
+		return deleted, fmt.Errorf("%d events could not be deleted from %s", failed, name)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,45 @@
+package syncer
+
+import "syncal/internal/models"
+
+// DefaultRedactedTitle is the title of redacted events when none is configured.
+const DefaultRedactedTitle = "Busy"
+
+// Redaction hides the details of events, so that a target calendar only shows when someone is busy.
+// Events marked private or confidential in their source are always redacted.
+type Redaction struct {
+	// All redacts every event, not only private ones.
+	All bool
+	// Title replaces the title of redacted events. It defaults to DefaultRedactedTitle.
+	Title string
+}
+
+// redact returns the event without its details if it has to be redacted, and whether it was.
+// Only the times, the availability, reminders and color of the event, and the fields syncal needs to
+// track it are kept, so details added to models.Event later are left out of redacted events too.
+func (r Redaction) redact(event *models.Event) (*models.Event, bool) {
+	if !r.All && event.Visibility != "private" && event.Visibility != "confidential" {
+		return event, false
+	}
+	title := r.Title
+	if title == "" {
+		title = DefaultRedactedTitle
+	}
+	return &models.Event{
+		ID:           event.ID,
+		UID:          event.UID,
+		Title:        title,
+		StartTime:    event.StartTime,
+		EndTime:      event.EndTime,
+		Source:       event.Source,
+		Account:      event.Account,
+		CalendarID:   event.CalendarID,
+		Updated:      event.Updated,
+		Pipeline:     event.Pipeline,
+		Visibility:   event.Visibility,
+		Status:       event.Status,
+		Transparency: event.Transparency,
+		Reminders:    event.Reminders,
+		Color:        event.Color,
+	}, true
+}
@@ -0,0 +1,86 @@
+package syncer
+
+import (
+	"log/slog"
+	"slices"
+	"syncal/internal/htmltext"
+	"syncal/internal/models"
+	"syncal/internal/transform"
+)
+
+// DefaultMaxDescriptionLength is the number of characters descriptions are cut to when no other limit is set.
+// Descriptions that long are usually pasted documents or email threads, and make events slow to load.
+const DefaultMaxDescriptionLength = 10000
+
+// rewriter applies the changes a pipeline makes to events before they are written.
+type rewriter struct {
+	transform                *transform.Transform
+	markTentative            bool
+	omitAttendees            bool
+	conferenceLinks          ConferenceLinks
+	colors                   Colors
+	attachmentsInDescription bool
+	plainDescriptions        bool
+	maxDescriptionLength     int
+	reminders                []int
+	redaction                Redaction
+}
+
+// newRewriter returns the rewriter for the options of a Syncer, cutting descriptions at
+// DefaultMaxDescriptionLength when the options set no limit.
+func newRewriter(opts Options) rewriter {
+	if opts.MaxDescriptionLength == 0 {
+		opts.MaxDescriptionLength = DefaultMaxDescriptionLength
+	}
+	return rewriter{
+		transform:                opts.Transform,
+		markTentative:            opts.MarkTentative,
+		omitAttendees:            opts.OmitAttendees,
+		conferenceLinks:          opts.ConferenceLinks,
+		colors:                   opts.Colors,
+		attachmentsInDescription: opts.AttachmentsInDescription,
+		plainDescriptions:        opts.PlainDescriptions,
+		maxDescriptionLength:     opts.MaxDescriptionLength,
+		reminders:                opts.Reminders,
+		redaction:                opts.Redaction,
+	}
+}
+
+// rewrite returns the event as it is written to the target, and whether its details were redacted.
+// Filters see the events before they are rewritten.
+func (r rewriter) rewrite(logger *slog.Logger, event *models.Event) (*models.Event, bool) {
+	description := event.Description
+	if err := r.transform.Apply(event); err != nil {
+		logger.Warn("Could not transform event, writing it unchanged.", "title", event.Title, "error", err)
+	}
+	if r.markTentative && (event.ResponseStatus == "needsAction" || event.ResponseStatus == "tentative") {
+		event.Status = "tentative"
+	}
+	if r.omitAttendees {
+		event.Attendees = nil
+	}
+	// The description is cut before the footers are added, so that they are never cut off.
+	event.Description = htmltext.Truncate(event.Description, r.maxDescriptionLength)
+	if event.Conference.URL != "" {
+		if r.conferenceLinks.inDescription() {
+			event.Description = conferenceFooter(event)
+		}
+		if r.conferenceLinks == ConferenceDescription || r.conferenceLinks == ConferenceNone {
+			event.Conference = models.Conference{}
+		}
+	}
+	if r.attachmentsInDescription && len(event.Attachments) > 0 {
+		event.Description = attachmentsFooter(event)
+	}
+	r.colors.apply(event)
+
+	// The HTML version of the description only stands for the source's description as it is.
+	if r.plainDescriptions || event.Description != description {
+		event.DescriptionHTML = ""
+	}
+
+	if r.reminders != nil {
+		event.Reminders = slices.Clone(r.reminders)
+	}
+	return r.redaction.redact(event)
+}
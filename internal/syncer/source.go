@@ -0,0 +1,210 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"syncal/internal/google"
+	"syncal/internal/icloud"
+	"syncal/internal/ics"
+	"syncal/internal/models"
+	"syncal/internal/outlook"
+	"time"
+)
+
+// Source is a calendar service a pipeline reads events from.
+type Source interface {
+	// Fetch returns the events that overlap the time range from start to end.
+	// Calendars that can't be fetched are reported in the result rather than failing the whole fetch.
+	Fetch(ctx context.Context, start, end time.Time) *FetchResult
+}
+
+// Writer is a calendar a pipeline writes events to.
+// Events are identified by their iCalendar UID, so the target copy stays linked to the source event.
+type Writer interface {
+	// SyncEvent creates or updates the event and returns the ETag of the written copy.
+	// Unless owned is set, an existing event with the same UID that syncal didn't create is left untouched
+	// and an error wrapping the writer's foreign object error is returned.
+	SyncEvent(ctx context.Context, event *models.Event, owned bool) (string, error)
+	// DeleteEvent removes the event with the given UID, with the same ownership rules as SyncEvent.
+	DeleteEvent(ctx context.Context, uid string, owned bool) error
+	// ForeignCollisions returns how many UID collisions with foreign events were detected so far.
+	ForeignCollisions() int64
+	// CalendarKey identifies the calendar written to among the calendars of the same type.
+	CalendarKey() string
+}
+
+// batchWriter is a Writer that writes the changes of a whole sync cycle at once. Changes made between
+// Begin and Flush only reach the target when Flush succeeds.
+type batchWriter interface {
+	Begin(ctx context.Context) error
+	Flush(ctx context.Context) error
+}
+
+// FetchResult is what a Source returned for a sync window.
+type FetchResult struct {
+	Events []*models.Event
+	// Fetched maps the "account/calendarID" of every calendar fetched successfully to its number of events,
+	// so that events of calendars that failed aren't mistaken for deletions.
+	Fetched map[string]int
+	Errors  []error
+	// ReauthAccounts lists the accounts that have to go through the 'auth' command again.
+	ReauthAccounts []string
+}
+
+// isForeignObject reports whether err was caused by a writer refusing to touch an event syncal didn't create.
+func isForeignObject(err error) bool {
+	return errors.Is(err, icloud.ErrForeignObject) || errors.Is(err, google.ErrForeignObject) || errors.Is(err, ics.ErrForeignObject) ||
+		errors.Is(err, outlook.ErrForeignObject)
+}
+
+// googleSource reads events from the same calendars of one or more Google accounts.
+type googleSource struct {
+	logger      *slog.Logger
+	clients     []*google.CalendarClient
+	calendarIDs []string
+}
+
+// NewGoogleSource returns a Source reading the given calendars of every client's account.
+func NewGoogleSource(logger *slog.Logger, clients []*google.CalendarClient, calendarIDs []string) Source {
+	return &googleSource{logger: logger, clients: clients, calendarIDs: calendarIDs}
+}
+
+// Fetch implements Source.
+func (g *googleSource) Fetch(ctx context.Context, start, end time.Time) *FetchResult {
+	result := &FetchResult{Fetched: make(map[string]int)}
+	for _, client := range g.clients {
+		for _, calID := range g.calendarIDs {
+			key := client.Account() + "/" + calID
+			events, err := client.GetEvents(ctx, calID, start, end)
+			if err != nil {
+				g.logger.Error("Could not fetch events for a google calendar", "calendarID", calID, "error", err)
+				result.Errors = append(result.Errors, fmt.Errorf("fetching %s: %w", key, err))
+				if google.IsAuthError(err) && !slices.Contains(result.ReauthAccounts, client.Account()) {
+					result.ReauthAccounts = append(result.ReauthAccounts, client.Account())
+				}
+				continue
+			}
+			result.Fetched[key] = len(events)
+			result.Events = append(result.Events, events...)
+		}
+	}
+	return result
+}
+
+// outlookSource reads events from the same calendars of one or more Outlook accounts.
+type outlookSource struct {
+	logger      *slog.Logger
+	clients     []*outlook.Client
+	calendarIDs []string
+}
+
+// NewOutlookSource returns a Source reading the given calendars of every client's account.
+func NewOutlookSource(logger *slog.Logger, clients []*outlook.Client, calendarIDs []string) Source {
+	return &outlookSource{logger: logger, clients: clients, calendarIDs: calendarIDs}
+}
+
+// Fetch implements Source.
+func (o *outlookSource) Fetch(ctx context.Context, start, end time.Time) *FetchResult {
+	result := &FetchResult{Fetched: make(map[string]int)}
+	for _, client := range o.clients {
+		for _, calID := range o.calendarIDs {
+			key := client.Account() + "/" + calID
+			events, err := client.GetEvents(ctx, calID, start, end)
+			if err != nil {
+				o.logger.Error("Could not fetch events for an outlook calendar", "calendarID", calID, "error", err)
+				result.Errors = append(result.Errors, fmt.Errorf("fetching %s: %w", key, err))
+				reauth := "outlook/" + client.Account()
+				if outlook.IsAuthError(err) && !slices.Contains(result.ReauthAccounts, reauth) {
+					result.ReauthAccounts = append(result.ReauthAccounts, reauth)
+				}
+				continue
+			}
+			result.Fetched[key] = len(events)
+			result.Events = append(result.Events, events...)
+		}
+	}
+	return result
+}
+
+// icloudSource reads events from an iCloud calendar.
+type icloudSource struct {
+	logger *slog.Logger
+	client *icloud.CalDAVClient
+}
+
+// NewICloudSource returns a Source reading the calendar of the iCloud client.
+func NewICloudSource(logger *slog.Logger, client *icloud.CalDAVClient) Source {
+	return &icloudSource{logger: logger, client: client}
+}
+
+// Fetch implements Source.
+func (i *icloudSource) Fetch(ctx context.Context, start, end time.Time) *FetchResult {
+	result := &FetchResult{Fetched: make(map[string]int)}
+	key := i.client.CalendarKey()
+	// FetchEvents leaves out the events written by syncal, which came from another source and
+	// must not be echoed back, and expands recurring events like the other sources do.
+	events, err := i.client.FetchEvents(ctx, start, end)
+	if err != nil {
+		i.logger.Error("Could not fetch events for the icloud calendar", "error", err)
+		result.Errors = append(result.Errors, fmt.Errorf("fetching %s: %w", key, err))
+		return result
+	}
+	result.Events = events
+	result.Fetched[key] = len(events)
+	return result
+}
+
+// caldavSource reads events from a calendar on any CalDAV server, such as Nextcloud or Baikal.
+type caldavSource struct {
+	logger *slog.Logger
+	client *icloud.CalDAVClient
+}
+
+// NewCalDAVSource returns a Source reading the calendar of the CalDAV client.
+// Recurring events are expanded and only changes are downloaded after the first fetch.
+func NewCalDAVSource(logger *slog.Logger, client *icloud.CalDAVClient) Source {
+	return &caldavSource{logger: logger, client: client}
+}
+
+// Fetch implements Source.
+func (c *caldavSource) Fetch(ctx context.Context, start, end time.Time) *FetchResult {
+	result := &FetchResult{Fetched: make(map[string]int)}
+	key := c.client.CalendarKey()
+	events, err := c.client.FetchEvents(ctx, start, end)
+	if err != nil {
+		c.logger.Error("Could not fetch events for a caldav calendar", "calendar", key, "error", err)
+		result.Errors = append(result.Errors, fmt.Errorf("fetching %s: %w", key, err))
+		return result
+	}
+	result.Events = events
+	result.Fetched[key] = len(events)
+	return result
+}
+
+// icsSource reads events from an iCalendar feed.
+type icsSource struct {
+	logger *slog.Logger
+	feed   *ics.Feed
+}
+
+// NewICSSource returns a Source reading the given feed.
+func NewICSSource(logger *slog.Logger, feed *ics.Feed) Source {
+	return &icsSource{logger: logger, feed: feed}
+}
+
+// Fetch implements Source.
+func (i *icsSource) Fetch(ctx context.Context, start, end time.Time) *FetchResult {
+	result := &FetchResult{Fetched: make(map[string]int)}
+	events, err := i.feed.Events(ctx, start, end)
+	if err != nil {
+		i.logger.Error("Could not fetch events for an ics feed", "feed", i.feed.Key(), "error", err)
+		result.Errors = append(result.Errors, fmt.Errorf("fetching %s: %w", i.feed.Key(), err))
+		return result
+	}
+	result.Events = events
+	result.Fetched[i.feed.Key()] = len(events)
+	return result
+}
@@ -0,0 +1,248 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syncal/internal/models"
+	"time"
+)
+
+const (
+	stateFile = "sync-state.json"
+
+	// stateLockFile exists while a process is updating the state file.
+	stateLockFile = stateFile + ".lock"
+
+	// stateLockTimeout is how long to wait for another process to release the state file.
+	stateLockTimeout = 10 * time.Second
+
+	// staleLockAge is when a lock file is assumed to be left behind by a process that crashed.
+	staleLockAge = time.Minute
+
+	// DefaultPipeline is the pipeline name used when none is configured.
+	DefaultPipeline = "default"
+
+	// maxRunHistory is how many sync runs are kept in the state file.
+	maxRunHistory = 50
+)
+
+// SyncState keeps track of which events have been synced, grouped by pipeline.
+type SyncState struct {
+	Pipelines map[string]*PipelineState `json:"pipelines"`
+	Runs      []*RunRecord              `json:"runs,omitempty"` // Most recent last
+}
+
+// PipelineState holds the synced events of a single pipeline.
+// The key of both maps is the ID of the source event: the Google Event ID, or the UID for iCloud events.
+type PipelineState struct {
+	Target    string                  `json:"target,omitempty"`   // Where events are written, empty means TargetICloud
+	Calendar  string                  `json:"calendar,omitempty"` // CalendarKey of the writer events are written to
+	Events    map[string]*SyncedEvent `json:"events"`
+	Conflicts map[string]*Conflict    `json:"conflicts,omitempty"` // Quarantined conflicts
+}
+
+// Origins of synced events.
+const (
+	OriginGoogle = "google"
+	OriginICloud = "icloud"
+)
+
+// SyncedEvent links a source event to its counterpart in the target calendar.
+// Snapshot is the source event as it was last synced, used to detect changes and deletions.
+// It is nil for events synced before snapshots were recorded.
+type SyncedEvent struct {
+	UID      string        `json:"uid"`
+	Origin   string        `json:"origin,omitempty"`   // OriginICloud for iCloud events copied to Google by two-way sync
+	Path     string        `json:"path,omitempty"`     // iCloud object path, for events created in iCloud
+	ETag     string        `json:"etag,omitempty"`     // ETag of the target copy after the last sync
+	Redacted bool          `json:"redacted,omitempty"` // The target copy hides the details of the source event
+	Snapshot *models.Event `json:"snapshot,omitempty"`
+}
+
+// RunRecord is the outcome of a single sync cycle.
+type RunRecord struct {
+	Pipeline       string         `json:"pipeline"`
+	StartedAt      time.Time      `json:"startedAt"`
+	FinishedAt     time.Time      `json:"finishedAt"`
+	Fetched        map[string]int `json:"fetched"` // Events fetched per "account/calendarID"
+	Created        int            `json:"created"`
+	Updated        int            `json:"updated"`
+	Deleted        int            `json:"deleted"`
+	Failed         int            `json:"failed"`
+	Conflicts      int            `json:"conflicts"`            // Conflicts detected, resolved or not
+	Collisions     int            `json:"collisions,omitempty"` // Events skipped because their UID belongs to a foreign event
+	Errors         []string       `json:"errors,omitempty"`
+	ReauthAccounts []string       `json:"reauthAccounts,omitempty"` // Accounts whose token was rejected
+}
+
+// Succeeded reports whether the run finished without any errors.
+func (r *RunRecord) Succeeded() bool {
+	return len(r.Errors) == 0
+}
+
+// addError records an error that happened during the run.
+func (r *RunRecord) addError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// recordRun appends a run to the history, dropping the oldest runs beyond maxRunHistory.
+func (st *SyncState) recordRun(run *RunRecord) {
+	st.Runs = append(st.Runs, run)
+	if len(st.Runs) > maxRunHistory {
+		st.Runs = st.Runs[len(st.Runs)-maxRunHistory:]
+	}
+}
+
+// newSyncState returns an empty sync state.
+func newSyncState() *SyncState {
+	return &SyncState{Pipelines: make(map[string]*PipelineState)}
+}
+
+// pipeline returns the state of the named pipeline, creating it if needed.
+func (st *SyncState) pipeline(name string) *PipelineState {
+	ps, ok := st.Pipelines[name]
+	if !ok {
+		ps = &PipelineState{Events: make(map[string]*SyncedEvent)}
+		st.Pipelines[name] = ps
+	}
+	return ps
+}
+
+// target returns where the pipeline writes its events.
+func (ps *PipelineState) target() string {
+	if ps.Target == "" {
+		return TargetICloud
+	}
+	return ps.Target
+}
+
+// loadState loads the sync state from the JSON file.
+// State files written before pipelines existed (a flat map of Google Event ID to iCloud UID)
+// are migrated into the default pipeline.
+func loadState() (*SyncState, error) {
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if _, ok := raw["pipelines"]; ok {
+		state := newSyncState()
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, err
+		}
+		for name, ps := range state.Pipelines {
+			if ps == nil || ps.Events == nil {
+				state.Pipelines[name] = &PipelineState{Events: make(map[string]*SyncedEvent)}
+			}
+		}
+		return state, nil
+	}
+
+	var legacy map[string]string
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, fmt.Errorf("unrecognized sync state format: %w", err)
+	}
+	state := newSyncState()
+	ps := state.pipeline(DefaultPipeline)
+	for id, uid := range legacy {
+		ps.Events[id] = &SyncedEvent{UID: uid}
+	}
+	return state, nil
+}
+
+// reloadState reads the sync state from the JSON file, starting fresh if there is none yet.
+func (s *Syncer) reloadState() error {
+	state, err := loadState()
+	if err != nil {
+		// If the file doesn't exist, we can start with an empty state.
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to load sync state: %w", err)
+		}
+		s.logger.Info("No sync state file found, starting fresh.", "file", stateFile)
+		state = newSyncState()
+	}
+	s.state = state
+	return nil
+}
+
+// saveState saves the state of the syncer's pipeline and the runs it recorded to the JSON file.
+// The file is read again first, so that what other processes saved since the state was loaded,
+// like the state of other pipelines, their runs and conflict resolutions, is kept.
+func (s *Syncer) saveState() error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	saved, err := loadState()
+	if os.IsNotExist(err) {
+		saved = newSyncState()
+	} else if err != nil {
+		return fmt.Errorf("failed to load sync state: %w", err)
+	}
+
+	if ps, ok := s.state.Pipelines[s.pipeline]; ok {
+		if prev, ok := saved.Pipelines[s.pipeline]; ok {
+			mergeResolutions(ps, prev)
+		}
+		saved.Pipelines[s.pipeline] = ps
+	}
+	for _, run := range s.runs {
+		saved.recordRun(run)
+	}
+	if err := writeState(saved); err != nil {
+		return err
+	}
+	s.state, s.runs = saved, nil
+	return nil
+}
+
+// mergeResolutions copies the resolutions of conflicts in saved to the same conflicts in ps
+// that are still waiting for one.
+func mergeResolutions(ps, saved *PipelineState) {
+	for id, c := range ps.Conflicts {
+		if prev, ok := saved.Conflicts[id]; ok && c.Resolution == "" {
+			c.Resolution = prev.Resolution
+		}
+	}
+}
+
+// lockState takes the lock on the state file, so that a running sync and the conflicts command
+// don't overwrite each other's changes. The returned function releases the lock.
+func lockState() (func(), error) {
+	deadline := time.Now().Add(stateLockTimeout)
+	for {
+		f, err := os.OpenFile(stateLockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(stateLockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock sync state: %w", err)
+		}
+		if info, err := os.Stat(stateLockFile); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(stateLockFile)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("sync state is locked by another syncal process, remove %s if none is running", stateLockFile)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// writeState writes the sync state to the JSON file.
+func writeState(state *SyncState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync state: %w", err)
+	}
+	return os.WriteFile(stateFile, data, 0644)
+}
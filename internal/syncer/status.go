@@ -0,0 +1,72 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// PipelineStatus summarizes the recent sync runs of a pipeline.
+type PipelineStatus struct {
+	Pipeline       string     `json:"pipeline"`
+	SyncedEvents   int        `json:"syncedEvents"`
+	LastRun        *RunRecord `json:"lastRun,omitempty"`
+	LastSuccess    *RunRecord `json:"lastSuccess,omitempty"`
+	ReauthAccounts []string   `json:"reauthAccounts,omitempty"` // From the last run
+}
+
+// Status is a summary of the sync state, as shown by the status command.
+type Status struct {
+	Pipelines []PipelineStatus `json:"pipelines"`
+	Runs      []*RunRecord     `json:"runs"` // Most recent first
+}
+
+// LoadStatus reads the sync state file and summarizes the recorded runs.
+// At most maxRuns runs are included in the run list.
+func LoadStatus(maxRuns int) (*Status, error) {
+	state, err := loadState()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Status{}, nil
+		}
+		return nil, fmt.Errorf("failed to load sync state: %w", err)
+	}
+
+	byName := make(map[string]*PipelineStatus)
+	get := func(name string) *PipelineStatus {
+		ps, ok := byName[name]
+		if !ok {
+			ps = &PipelineStatus{Pipeline: name}
+			byName[name] = ps
+		}
+		return ps
+	}
+
+	for name, ps := range state.Pipelines {
+		get(name).SyncedEvents = len(ps.Events)
+	}
+
+	status := &Status{}
+	for i := len(state.Runs) - 1; i >= 0; i-- {
+		run := state.Runs[i]
+		ps := get(run.Pipeline)
+		if ps.LastRun == nil {
+			ps.LastRun = run
+			ps.ReauthAccounts = run.ReauthAccounts
+		}
+		if ps.LastSuccess == nil && run.Succeeded() {
+			ps.LastSuccess = run
+		}
+		if len(status.Runs) < maxRuns {
+			status.Runs = append(status.Runs, run)
+		}
+	}
+
+	for _, ps := range byName {
+		status.Pipelines = append(status.Pipelines, *ps)
+	}
+	sort.Slice(status.Pipelines, func(i, j int) bool {
+		return status.Pipelines[i].Pipeline < status.Pipelines[j].Pipeline
+	})
+	return status, nil
+}
@@ -2,160 +2,402 @@ package syncer
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"maps"
 	"strings"
+	"syncal/internal/filter"
 	"syncal/internal/google"
 	"syncal/internal/icloud"
-	"syncal/internal/models"
+	"syncal/internal/ics"
+	"syncal/internal/outlook"
+	"syncal/internal/transform"
 	"time"
 )
 
-const stateFile = "sync-state.json"
+// Options configures a Syncer.
+type Options struct {
+	// Pipeline scopes the sync state and is stamped on every event written to the target.
+	Pipeline string
+	// DryRun logs the planned changes without making them.
+	DryRun bool
+	// TimeZone is the location event times are normalized to.
+	TimeZone *time.Location
+	// TwoWay, when set, also copies events created or changed in the iCloud target back to Google.
+	TwoWay *TwoWay
+	// ConflictPolicy decides which side wins when an event changed on both sides in two-way sync.
+	// It defaults to PolicySourceWins.
+	ConflictPolicy ConflictPolicy
+	// Filter selects the source events to sync. Previously synced events it rejects are deleted from the target.
+	Filter *filter.Filter
+	// Transform rewrites events before they are written. It runs after Filter and before Redaction.
+	Transform *transform.Transform
+	// MarkTentative writes events the owner of the source calendar hasn't accepted yet, or accepted
+	// tentatively, with the tentative status.
+	MarkTentative bool
+	// OmitAttendees writes events without their attendees. The organizer is kept.
+	OmitAttendees bool
+	// ConferenceLinks decides how links to video calls are written. It defaults to ConferenceURL.
+	ConferenceLinks ConferenceLinks
+	// AttachmentsInDescription appends links to the attachments of events to their descriptions,
+	// for targets that ignore attachments.
+	AttachmentsInDescription bool
+	// Colors sets the colors of events, and the categories that go with them.
+	Colors Colors
+	// PlainDescriptions drops the HTML version of descriptions, writing their plain text only.
+	PlainDescriptions bool
+	// MaxDescriptionLength is the number of characters descriptions are cut to. It defaults to
+	// DefaultMaxDescriptionLength.
+	MaxDescriptionLength int
+	// Reminders, when not nil, replace the reminders of every event, in minutes before its start.
+	// An empty, non-nil slice removes them.
+	Reminders []int
+	// Redaction hides the details of events in the target.
+	Redaction Redaction
+}
 
-// SyncState keeps track of which events have been synced.
-// The key is the Google Event ID, and the value is the UID of the event in iCloud.
-type SyncState map[string]string
+// TwoWay holds the clients two-way sync between Google and iCloud writes changes back with.
+type TwoWay struct {
+	// Google are the clients of the source accounts, events are updated in the account they came from.
+	Google []*google.CalendarClient
+	// WriteCalendar is the "account/calendarID" that receives events created in iCloud.
+	WriteCalendar string
+}
 
-// Syncer orchestrates the synchronization from Google Calendar to iCloud.
+// Syncer orchestrates the synchronization from a source to a target calendar.
 type Syncer struct {
 	logger          *slog.Logger
-	googleClients   []*google.CalendarClient
-	googleCalIDs    []string
-	icloudClient    *icloud.CalDAVClient
-	state           SyncState
+	source          Source
+	writer          Writer
+	target          string // TargetICloud, TargetCalDAV, TargetGoogle, TargetICS or TargetOutlook
+	pipeline        string
+	state           *SyncState
+	runs            []*RunRecord // runs recorded since the state was last saved
 	dryRun          bool
 	primaryTimeZone *time.Location
+	conflictPolicy  ConflictPolicy
+	filter          *filter.Filter
+	rewriter        rewriter
+
+	// Two-way sync only.
+	twoWay          bool
+	googleClients   []*google.CalendarClient
+	icloudClient    *icloud.CalDAVClient
+	writeAccount    string
+	writeCalendarID string
 }
 
-// NewSyncer creates a new Syncer.
-func NewSyncer(logger *slog.Logger, gClients []*google.CalendarClient, gCalIDs []string, iClient *icloud.CalDAVClient, dryRun bool, tz *time.Location) (*Syncer, error) {
-	state, err := loadState()
-	if err != nil {
-		// If the file doesn't exist, we can start with an empty state.
-		if os.IsNotExist(err) {
-			logger.Info("No sync state file found, starting fresh.", "file", stateFile)
-			state = make(SyncState)
-		} else {
-			return nil, fmt.Errorf("failed to load sync state: %w", err)
-		}
+// NewSyncer creates a Syncer that copies the events of source to the calendar of writer.
+// The writer must be an *icloud.CalDAVClient, a *google.CalendarWriter, an *ics.FileWriter, an *ics.DirWriter
+// or an *outlook.CalendarWriter. To sync one source to several targets, create a Syncer per target
+// and group them with NewPipeline, which fetches the source once per cycle.
+// A nil source is enough for syncers that only purge.
+func NewSyncer(logger *slog.Logger, source Source, writer Writer, opts Options) (*Syncer, error) {
+	if opts.Pipeline == "" {
+		opts.Pipeline = DefaultPipeline
+	}
+	if opts.TimeZone == nil {
+		opts.TimeZone = time.UTC
+	}
+	if opts.ConflictPolicy == "" {
+		opts.ConflictPolicy = PolicySourceWins
 	}
 
-	return &Syncer{
+	s := &Syncer{
 		logger:          logger,
-		googleClients:   gClients,
-		googleCalIDs:    gCalIDs,
-		icloudClient:    iClient,
-		state:           state,
-		dryRun:          dryRun,
-		primaryTimeZone: tz,
-	}, nil
+		source:          source,
+		writer:          writer,
+		pipeline:        opts.Pipeline,
+		dryRun:          opts.DryRun,
+		primaryTimeZone: opts.TimeZone,
+		conflictPolicy:  opts.ConflictPolicy,
+		filter:          opts.Filter,
+		rewriter:        newRewriter(opts),
+	}
+
+	switch w := writer.(type) {
+	case *icloud.CalDAVClient:
+		s.target, s.icloudClient = TargetICloud, w
+		if !w.IsICloud() {
+			s.target = TargetCalDAV
+		}
+	case *google.CalendarWriter:
+		s.target = TargetGoogle
+	case *ics.FileWriter, *ics.DirWriter:
+		s.target = TargetICS
+	case *outlook.CalendarWriter:
+		s.target = TargetOutlook
+	default:
+		return nil, fmt.Errorf("unsupported writer %T", writer)
+	}
+
+	if opts.TwoWay != nil {
+		if s.target != TargetICloud {
+			return nil, fmt.Errorf("two-way sync needs an iCloud target")
+		}
+		s.twoWay, s.googleClients = true, opts.TwoWay.Google
+		account, calID, ok := strings.Cut(opts.TwoWay.WriteCalendar, "/")
+		if !ok || account == "" || calID == "" {
+			return nil, fmt.Errorf("two-way sync needs a Google write calendar in the form 'account/calendarID', got '%s'", opts.TwoWay.WriteCalendar)
+		}
+		// Events the filter rejects are deleted from the target, including iCloud originals.
+		if opts.Filter != nil {
+			return nil, fmt.Errorf("two-way sync can't be combined with filters")
+		}
+		// newRewriter applies DefaultMaxDescriptionLength when no limit is set, so the limit is lifted here:
+		// a description cut in iCloud would be written back to Google when the event is edited there.
+		s.rewriter.maxDescriptionLength = 0
+		// The link would be written back to Google with the description, and appended again.
+		if opts.ConferenceLinks.inDescription() || opts.AttachmentsInDescription {
+			return nil, fmt.Errorf("two-way sync can't add conference or attachment links to descriptions")
+		}
+		if s.googleClient(account) == nil {
+			return nil, fmt.Errorf("no authenticated Google account named '%s' for the write calendar", account)
+		}
+		s.writeAccount, s.writeCalendarID = account, calID
+	}
+
+	if err := s.reloadState(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // Sync performs a full synchronization cycle.
+// The outcome of the cycle is recorded in the sync state and reported by the status command.
 func (s *Syncer) Sync(ctx context.Context) error {
-	s.logger.Info("Starting sync cycle.")
+	return s.sync(ctx, s.source)
+}
+
+// sync implements Sync, reading the events from the given source.
+func (s *Syncer) sync(ctx context.Context, source Source) error {
+	s.logger.Info("Starting sync cycle.", "pipeline", s.pipeline)
+	run := &RunRecord{Pipeline: s.pipeline, StartedAt: time.Now(), Fetched: make(map[string]int)}
+	collisions := s.writer.ForeignCollisions()
 
-	googleEvents, err := s.fetchAllGoogleEvents(ctx)
+	// Other pipelines share the state file and may have saved it since the last cycle.
+	// A state file that can't be read can't record the run either, and status reports the error itself.
+	if err := s.reloadState(); err != nil {
+		return err
+	}
+
+	plan, err := s.plan(ctx, source, run)
 	if err != nil {
-		return fmt.Errorf("failed to fetch google events: %w", err)
+		run.addError(err)
+		s.saveRun(run)
+		return err
 	}
 
-	s.logger.Info("Fetched all Google events.", "count", len(googleEvents))
+	if err := s.applyPlan(ctx, plan, run); err != nil {
+		s.logger.Error("Failed to write changes", "target", s.target, "error", err)
+		run.addError(err)
+	}
+	s.recordConflicts(plan, run)
+	run.Collisions = int(s.writer.ForeignCollisions() - collisions)
+	if !s.dryRun {
+		ps := s.state.pipeline(s.pipeline)
+		ps.Target, ps.Calendar = s.target, s.writer.CalendarKey()
+	}
+	s.saveRun(run)
 
-	for _, event := range googleEvents {
-		err := s.syncEvent(ctx, event)
-		if err != nil {
-			s.logger.Error("Failed to sync event", "title", event.Title, "error", err)
-			// Continue with the next event even if one fails.
-		}
+	if run.Collisions > 0 {
+		s.logger.Warn("Foreign events with colliding UIDs were left untouched.", "collisions", run.Collisions, "totalCollisions", s.writer.ForeignCollisions())
 	}
 
-	if !s.dryRun {
-		if err := s.saveState(); err != nil {
-			s.logger.Error("Failed to save sync state", "error", err)
-		}
+	s.logger.Info("Sync cycle finished.", "created", run.Created, "updated", run.Updated, "deleted", run.Deleted, "failed", run.Failed)
+	return nil
+}
+
+// saveRun records a finished run in the sync state and saves it, unless this is a dry run.
+func (s *Syncer) saveRun(run *RunRecord) {
+	run.FinishedAt = time.Now()
+	if s.dryRun {
+		return
+	}
+	s.runs = append(s.runs, run)
+	if err := s.saveState(); err != nil {
+		s.logger.Error("Failed to save sync state", "error", err)
 	}
+}
 
-	s.logger.Info("Sync cycle finished.")
+// applyPlan carries out the changes of a plan. Writers that write a whole cycle at once get all the
+// changes before they are written; if that fails, the sync state forgets the changes again.
+func (s *Syncer) applyPlan(ctx context.Context, plan *Plan, run *RunRecord) error {
+	batch, ok := s.writer.(batchWriter)
+	if !ok || s.dryRun {
+		s.applyChanges(ctx, plan, run)
+		return nil
+	}
+
+	if err := batch.Begin(ctx); err != nil {
+		return fmt.Errorf("failed to read %s: %w", s.target, err)
+	}
+	ps := s.state.pipeline(s.pipeline)
+	events := maps.Clone(ps.Events)
+	s.applyChanges(ctx, plan, run)
+	if err := batch.Flush(ctx); err != nil {
+		ps.Events = events
+		run.Failed += run.Created + run.Updated + run.Deleted
+		run.Created, run.Updated, run.Deleted = 0, 0, 0
+		return fmt.Errorf("failed to write %s: %w", s.target, err)
+	}
 	return nil
 }
 
-// fetchAllGoogleEvents retrieves events from all configured Google Calendars.
-func (s *Syncer) fetchAllGoogleEvents(ctx context.Context) ([]*models.Event, error) {
-	var allEvents []*models.Event
-	calendarIDs := strings.Split(s.googleCalIDs[0], ",")
+// applyChanges carries out the changes of a plan one by one, recording the changes that failed in run.
+func (s *Syncer) applyChanges(ctx context.Context, plan *Plan, run *RunRecord) {
+	// Unchanged events only refresh their state entry. The snapshot of a Google event must not undo
+	// what another change to the same event does, like an iCloud edit that two-way sync writes back.
+	touched := make(map[string]bool)
+	for _, change := range plan.Changes {
+		if change.Action != ActionUnchanged && change.SourceID != "" {
+			touched[change.SourceID] = true
+		}
+	}
 
-	for _, client := range s.googleClients {
-		for _, calID := range calendarIDs {
-			events, err := client.GetUpcomingEvents(calID, 7) // Fetch events for the next 7 days
-			if err != nil {
-				s.logger.Error("Could not fetch events for a google calendar", "calendarID", calID, "error", err)
-				continue
-			}
-			allEvents = append(allEvents, events...)
+	// The refreshed ETags of iCloud objects are needed first, by the writes conditional on them.
+	var unchanged, changed []Change
+	for _, change := range plan.Changes {
+		switch {
+		case change.Action != ActionUnchanged:
+			changed = append(changed, change)
+		case change.Target != s.target || !touched[change.SourceID]:
+			unchanged = append(unchanged, change)
+		}
+	}
+
+	for _, change := range append(unchanged, changed...) {
+		if err := s.apply(ctx, change, run); err != nil {
+			s.logger.Error("Failed to sync event", "title", change.Title, "action", change.Action, "error", err)
+			run.Failed++
+			run.addError(err)
+			// Continue with the next event even if one fails.
 		}
 	}
-	return allEvents, nil
 }
 
-// syncEvent handles the logic for syncing a single event.
-func (s *Syncer) syncEvent(ctx context.Context, event *models.Event) error {
-	// Check if this event has already been synced.
-	if _, exists := s.state[event.ID]; exists {
-		// For now, we don't handle updates. In the future, we could check LastModified.
-		s.logger.Debug("Event already synced, skipping.", "title", event.Title, "id", event.ID)
-		return nil
+// apply carries out a single planned change and records it in the sync state.
+func (s *Syncer) apply(ctx context.Context, change Change, run *RunRecord) error {
+	if change.Target != s.target {
+		// Changes made in iCloud that two-way sync writes back to Google.
+		return s.applyToGoogle(ctx, change, run)
 	}
+	return s.applyToTarget(ctx, change, run)
+}
 
-	s.logger.Info("New event found, syncing to iCloud.", "title", event.Title)
+// applyToTarget carries out a planned change against the target calendar.
+func (s *Syncer) applyToTarget(ctx context.Context, change Change, run *RunRecord) error {
+	synced := s.state.pipeline(s.pipeline).Events
+	prev := synced[change.SourceID]
+	name := targetNames[s.target]
 
-	// We need to generate a new UID for the iCloud event, but store the mapping.
-	// We use the Google iCal UID to ensure consistency if we sync from another client.
-	if event.UID == "" {
-		s.logger.Warn("Google event has no UID, generating a new one.", "title", event.Title)
-		event.UID = icloud.GenerateUID()
-	}
+	switch change.Action {
+	case ActionUnchanged:
+		s.logger.Debug("Event already synced, skipping.", "title", change.Title, "id", change.SourceID)
+		// Keep the snapshot current with fields that aren't compared, like the source account.
+		if prev != nil && change.event != nil {
+			prev.Snapshot = change.event
+		}
+		return nil
 
-	// Adjust times to the primary timezone
-	event.StartTime = event.StartTime.In(s.primaryTimeZone)
-	event.EndTime = event.EndTime.In(s.primaryTimeZone)
+	case ActionDelete:
+		if s.dryRun {
+			s.logger.Info(fmt.Sprintf("[DRY RUN] Would delete event from %s", name), "title", change.Title, "uid", change.UID)
+			return nil
+		}
+		s.logger.Info(fmt.Sprintf("Event removed from source, deleting from %s.", name), "title", change.Title)
+		var err error
+		if prev != nil && prev.Origin == OriginICloud {
+			err = s.icloudClient.DeleteObject(ctx, prev.Path)
+		} else {
+			err = s.writer.DeleteEvent(ctx, change.UID, true)
+		}
+		if isForeignObject(err) {
+			s.logger.Warn("Forgetting event whose target copy is no longer managed by syncal.", "title", change.Title, "uid", change.UID)
+			delete(synced, change.SourceID)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete event from %s: %w", s.target, err)
+		}
+		delete(synced, change.SourceID)
+		run.Deleted++
+		return nil
+	}
 
+	event := change.event
 	if s.dryRun {
-		s.logger.Info("[DRY RUN] Would create new event in iCloud", "title", event.Title, "startTime", event.StartTime)
+		s.logger.Info(fmt.Sprintf("[DRY RUN] Would %s event in %s", change.Action, name), "title", event.Title, "startTime", event.StartTime)
 		return nil
 	}
 
-	err := s.icloudClient.SyncEvent(ctx, event)
+	var etag string
+	var err error
+	if prev != nil && prev.Origin == OriginICloud {
+		s.logger.Info("Event changed in Google, updating the iCloud original.", "title", event.Title)
+		etag, err = s.icloudClient.UpdateObject(ctx, prev.Path, prev.ETag, event)
+		if errors.Is(err, icloud.ErrObjectChanged) {
+			// Edited in iCloud since the plan was made. The state entry is left as it was, so the next
+			// cycle sees the change on both sides and settles it with the conflict policy.
+			s.logger.Warn("Event changed in iCloud while it was synced, checking it again next cycle.", "title", event.Title, "uid", event.UID)
+			return nil
+		}
+	} else {
+		owned := prev != nil
+		if change.Action == ActionCreate {
+			s.logger.Info(fmt.Sprintf("New event found, syncing to %s.", name), "title", event.Title)
+			owned = owned || s.ownsUID(event.UID)
+		} else {
+			s.logger.Info(fmt.Sprintf("Event changed, updating in %s.", name), "title", event.Title)
+		}
+		etag, err = s.writer.SyncEvent(ctx, event, owned)
+	}
+	if isForeignObject(err) {
+		s.logger.Warn("Skipping event whose UID belongs to a foreign event.", "title", event.Title, "uid", event.UID, "target", s.target)
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to sync event to icloud: %w", err)
+		return fmt.Errorf("failed to sync event to %s: %w", s.target, err)
 	}
 
 	// If successful, update the state.
-	s.state[event.ID] = event.UID
+	entry := &SyncedEvent{UID: event.UID, Snapshot: event, ETag: etag, Redacted: change.redacted}
+	if prev != nil {
+		entry.Origin, entry.Path = prev.Origin, prev.Path
+	}
+	synced[change.SourceID] = entry
+	if change.Action == ActionCreate {
+		run.Created++
+	} else {
+		run.Updated++
+	}
 	return nil
 }
 
-// loadState loads the sync state from the JSON file.
-func loadState() (SyncState, error) {
-	data, err := os.ReadFile(stateFile)
-	if err != nil {
-		return nil, err
-	}
-	var state SyncState
-	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, err
+// googleClient returns the client of the named account, or nil if there is none.
+func (s *Syncer) googleClient(account string) *google.CalendarClient {
+	for _, client := range s.googleClients {
+		if client.Account() == account {
+			return client
+		}
 	}
-	return state, nil
+	return nil
 }
 
-// saveState saves the current sync state to the JSON file.
-func (s *Syncer) saveState() error {
-	data, err := json.MarshalIndent(s.state, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal sync state: %w", err)
+// ownsUID reports whether the sync state of any pipeline writing to the same calendar maps some event to the given UID.
+// Pipelines whose calendar isn't recorded yet, because they haven't finished a cycle since it was, don't count.
+func (s *Syncer) ownsUID(uid string) bool {
+	calendar := s.writer.CalendarKey()
+	for name, ps := range s.state.Pipelines {
+		if ps.target() != s.target || (ps.Calendar != calendar && name != s.pipeline) {
+			continue
+		}
+		for _, synced := range ps.Events {
+			if synced.UID == uid {
+				return true
+			}
+		}
 	}
-	return os.WriteFile(stateFile, data, 0644)
+	return false
 }
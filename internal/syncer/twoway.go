@@ -0,0 +1,277 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"syncal/internal/models"
+	"time"
+)
+
+// planReverse adds to the plan the changes made in iCloud that must be copied to Google.
+// Only events in the Google write calendar, or created in iCloud, are written back to Google.
+// Edits in iCloud to events of other Google calendars are undone by restoring the Google version.
+// Events that changed on both sides are resolved according to the conflict policy.
+func (s *Syncer) planReverse(ctx context.Context, plan *Plan, start, end time.Time) error {
+	remote, err := s.icloudClient.ListEvents(ctx, start, end)
+	if err != nil {
+		return fmt.Errorf("failed to list icloud events: %w", err)
+	}
+
+	ps := s.state.pipeline(s.pipeline)
+	synced := ps.Events
+
+	// Index the state by iCloud UID. A UID shared by several Google events (like the instances of a
+	// recurring meeting) maps to a single iCloud object that can't stand for all of them, so it is left out.
+	byUID := make(map[string]string)
+	shared := make(map[string]bool)
+	for id, entry := range synced {
+		if _, dup := byUID[entry.UID]; dup {
+			shared[entry.UID] = true
+		}
+		byUID[entry.UID] = id
+	}
+
+	// Index the Google side of the plan by Google event ID.
+	googleSide := make(map[string]int)
+	for i, change := range plan.Changes {
+		googleSide[change.SourceID] = i
+	}
+	dropped := make(map[int]bool)
+
+	present := make(map[string]bool)
+	for i := range remote {
+		r := &remote[i]
+		event := r.Event
+		present[event.UID] = true
+		if shared[event.UID] {
+			continue
+		}
+
+		event.Pipeline = s.pipeline
+		event.StartTime = event.StartTime.In(s.primaryTimeZone)
+		event.EndTime = event.EndTime.In(s.primaryTimeZone)
+		change := Change{Target: TargetGoogle, Pipeline: s.pipeline, UID: event.UID, Title: event.Title, Start: event.StartTime, event: event, from: r}
+
+		id, tracked := byUID[event.UID]
+		if !tracked {
+			// Objects written by syncal but unknown to this pipeline belong to another pipeline.
+			if r.Managed || s.ownsUID(event.UID) {
+				continue
+			}
+			change.Action = ActionCreate
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+
+		entry := synced[id]
+		change.SourceID = id
+		if entry.ETag == r.ETag {
+			continue
+		}
+
+		// Without an ETag or snapshot to compare with, just remember the current version.
+		change.Action = ActionUnchanged
+		if entry.ETag != "" && entry.Snapshot != nil {
+			change.Fields = diffWritableFields(entry.Snapshot, event)
+		}
+		if len(change.Fields) == 0 {
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+
+		i, hasGoogleSide := googleSide[id]
+		googleChanged := hasGoogleSide && plan.Changes[i].Action != ActionUnchanged
+		if !s.writableInGoogle(entry) {
+			if !googleChanged && hasGoogleSide {
+				s.logger.Info("Event from a read-only Google calendar was edited in iCloud, restoring the Google version.", "title", event.Title)
+				plan.Changes[i].Action = ActionUpdate
+				plan.Changes[i].Fields = diffWritableFields(event, plan.Changes[i].event)
+			}
+			continue
+		}
+		if !googleChanged {
+			change.Action = ActionUpdate
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+
+		// Changed on both sides since the last sync.
+		googleChange := &plan.Changes[i]
+		conflict := s.newConflict(ps, id, entry, googleChange.Action, ActionUpdate)
+		conflict.TargetModified = event.Updated
+		if googleChange.Action == ActionUpdate {
+			conflict.SourceModified = googleChange.event.Updated
+			conflict.Fields = diffWritableFields(googleChange.event, event)
+		}
+		switch s.resolveConflict(plan, conflict) {
+		case sideSource:
+			// The Google side of the plan overwrites or deletes the iCloud copy.
+		case sideTarget:
+			dropped[i] = true
+			if googleChange.Action == ActionDelete {
+				// Deleted in Google: bring the iCloud version back as a new Google event.
+				change.Action = ActionCreate
+			} else {
+				change.Action = ActionUpdate
+			}
+			plan.Changes = append(plan.Changes, change)
+		default:
+			dropped[i] = true
+		}
+	}
+
+	// Events that disappeared from iCloud.
+	for id, entry := range synced {
+		if present[entry.UID] || entry.Snapshot == nil || !inWindow(entry.Snapshot, start, end) {
+			continue
+		}
+
+		i, hasGoogleSide := googleSide[id]
+		deletion := Change{
+			Action:   ActionDelete,
+			Target:   TargetGoogle,
+			Pipeline: s.pipeline,
+			SourceID: id,
+			UID:      entry.UID,
+			Title:    entry.Snapshot.Title,
+			Start:    entry.Snapshot.StartTime,
+		}
+
+		switch {
+		case hasGoogleSide && plan.Changes[i].Action == ActionDelete:
+			// Gone on both sides, so only the state entry needs to go.
+			plan.Changes[i] = deletion
+		case !s.writableInGoogle(entry):
+			if hasGoogleSide {
+				s.logger.Info("Event from a read-only Google calendar was deleted in iCloud, restoring it.", "title", entry.Snapshot.Title)
+				plan.Changes[i].Action = ActionCreate
+			}
+		case hasGoogleSide && plan.Changes[i].Action == ActionUpdate:
+			// Deleted in iCloud but changed in Google.
+			googleChange := &plan.Changes[i]
+			conflict := s.newConflict(ps, id, entry, ActionUpdate, ActionDelete)
+			conflict.SourceModified = googleChange.event.Updated
+			switch s.resolveConflict(plan, conflict) {
+			case sideSource:
+				googleChange.Action = ActionCreate
+			case sideTarget:
+				dropped[i] = true
+				plan.Changes = append(plan.Changes, deletion)
+			default:
+				dropped[i] = true
+			}
+		default:
+			plan.Changes = append(plan.Changes, deletion)
+		}
+	}
+
+	if len(dropped) > 0 {
+		kept := plan.Changes[:0]
+		for i, change := range plan.Changes {
+			if !dropped[i] {
+				kept = append(kept, change)
+			}
+		}
+		plan.Changes = kept
+	}
+	return nil
+}
+
+// writableInGoogle reports whether changes made in iCloud to the event may be written back to Google.
+func (s *Syncer) writableInGoogle(entry *SyncedEvent) bool {
+	// The iCloud copy of a redacted event lacks the details that would be overwritten.
+	if entry.Redacted {
+		return false
+	}
+	if entry.Origin == OriginICloud {
+		return true
+	}
+	return entry.Snapshot != nil && entry.Snapshot.Account == s.writeAccount && entry.Snapshot.CalendarID == s.writeCalendarID
+}
+
+// diffWritableFields is like diffEvents, but only compares the fields that are written to Google.
+func diffWritableFields(old, cur *models.Event) []FieldChange {
+	var changes []FieldChange
+	for _, fc := range diffEvents(old, cur) {
+		switch fc.Field {
+		case "organizer", "attendees", "categories", "link":
+		case "html description":
+			// Clients that only edit DESCRIPTION drop the HTML version, and its changes show up there.
+		default:
+			changes = append(changes, fc)
+		}
+	}
+	return changes
+}
+
+// applyToGoogle carries out a planned change against Google Calendar.
+func (s *Syncer) applyToGoogle(ctx context.Context, change Change, run *RunRecord) error {
+	synced := s.state.pipeline(s.pipeline).Events
+	entry := synced[change.SourceID]
+
+	if change.Action == ActionUnchanged {
+		if entry != nil && change.from != nil {
+			entry.ETag = change.from.ETag
+		}
+		return nil
+	}
+
+	if s.dryRun {
+		s.logger.Info(fmt.Sprintf("[DRY RUN] Would %s event in Google", change.Action), "title", change.Title, "startTime", change.Start)
+		return nil
+	}
+
+	if change.Action == ActionCreate {
+		s.logger.Info("New event found in iCloud, syncing to Google.", "title", change.Title)
+		created, err := s.googleClient(s.writeAccount).InsertEvent(ctx, s.writeCalendarID, change.event)
+		if err != nil {
+			return fmt.Errorf("failed to sync event to google: %w", err)
+		}
+		if created == nil {
+			return fmt.Errorf("google returned an event that can't be tracked for '%s'", change.Title)
+		}
+		// A conflict resolution can recreate an event that was deleted in Google, replacing its old entry.
+		if change.SourceID != "" {
+			delete(synced, change.SourceID)
+		}
+		synced[created.ID] = &SyncedEvent{
+			UID:      change.UID,
+			Origin:   OriginICloud,
+			Path:     change.from.Path,
+			ETag:     change.from.ETag,
+			Snapshot: created,
+		}
+		run.Created++
+		return nil
+	}
+
+	if entry == nil || entry.Snapshot == nil {
+		return fmt.Errorf("no sync state for event '%s'", change.Title)
+	}
+	client := s.googleClient(entry.Snapshot.Account)
+	if client == nil {
+		return fmt.Errorf("no authenticated Google account named '%s'", entry.Snapshot.Account)
+	}
+
+	if change.Action == ActionDelete {
+		s.logger.Info("Event removed from iCloud, deleting from Google.", "title", change.Title)
+		if err := client.DeleteEvent(ctx, entry.Snapshot.CalendarID, change.SourceID); err != nil {
+			return fmt.Errorf("failed to delete event from google: %w", err)
+		}
+		delete(synced, change.SourceID)
+		run.Deleted++
+		return nil
+	}
+
+	s.logger.Info("Event changed in iCloud, updating in Google.", "title", change.Title)
+	updated, err := client.UpdateEvent(ctx, entry.Snapshot.CalendarID, change.SourceID, change.event)
+	if err != nil {
+		return fmt.Errorf("failed to update event in google: %w", err)
+	}
+	if updated != nil {
+		entry.Snapshot = updated
+	}
+	entry.ETag = change.from.ETag
+	run.Updated++
+	return nil
+}
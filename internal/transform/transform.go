@@ -0,0 +1,135 @@
+// Package transform rewrites events on their way to a target with text/template templates.
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"syncal/internal/models"
+	"text/template"
+	"time"
+)
+
+// Templates are the transformations of a pipeline. Each template is a Go text/template executed with
+// the event (a models.Event) as data, and its output replaces the field. Fields without a template are
+// left as they are.
+type Templates struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Location    string `json:"location,omitempty"`
+	// Categories is split at commas into the category names.
+	Categories string `json:"categories,omitempty"`
+}
+
+// Transform is a compiled set of Templates. A nil Transform leaves events unchanged.
+type Transform struct {
+	title       *template.Template
+	description *template.Template
+	location    *template.Template
+	categories  *template.Template
+}
+
+// funcs are the functions available to templates, in addition to the text/template builtins.
+var funcs = template.FuncMap{
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"replace":   strings.ReplaceAll,
+	"join":      strings.Join,
+	"default": func(fallback, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+// New compiles the templates, returning nil if there are none. Templates are also tried on a sample
+// event, so that references to unknown fields are reported here rather than during a sync.
+func New(t Templates) (*Transform, error) {
+	tr := &Transform{}
+	fields := []struct {
+		name string
+		text string
+		tmpl **template.Template
+	}{
+		{"title", t.Title, &tr.title},
+		{"description", t.Description, &tr.description},
+		{"location", t.Location, &tr.location},
+		{"categories", t.Categories, &tr.categories},
+	}
+
+	empty := true
+	sample := &models.Event{Title: "Sample", StartTime: time.Now(), EndTime: time.Now().Add(time.Hour)}
+	for _, f := range fields {
+		if f.text == "" {
+			continue
+		}
+		empty = false
+		tmpl, err := template.New(f.name).Funcs(funcs).Option("missingkey=error").Parse(f.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", f.name, err)
+		}
+		if _, err := execute(tmpl, sample); err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", f.name, err)
+		}
+		*f.tmpl = tmpl
+	}
+	if empty {
+		return nil, nil
+	}
+	return tr, nil
+}
+
+// Apply rewrites the fields of the event that have a template. All templates see the event as it was
+// before any of them ran. If a template fails, the event is left unchanged.
+func (t *Transform) Apply(event *models.Event) error {
+	if t == nil {
+		return nil
+	}
+	orig := event.Clone()
+	out := *event
+	for _, f := range []struct {
+		tmpl *template.Template
+		set  func(string)
+	}{
+		{t.title, func(s string) { out.Title = s }},
+		{t.description, func(s string) { out.Description = s }},
+		{t.location, func(s string) { out.Location = s }},
+		{t.categories, func(s string) { out.Categories = splitList(s) }},
+	} {
+		if f.tmpl == nil {
+			continue
+		}
+		s, err := execute(f.tmpl, orig)
+		if err != nil {
+			return fmt.Errorf("%s template failed: %w", f.tmpl.Name(), err)
+		}
+		f.set(s)
+	}
+	*event = out
+	return nil
+}
+
+// execute runs a template on an event.
+func execute(tmpl *template.Template, event *models.Event) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
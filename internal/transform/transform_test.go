@@ -0,0 +1,86 @@
+package transform
+
+import (
+	"slices"
+	"strings"
+	"syncal/internal/models"
+	"testing"
+	"time"
+)
+
+func TestNewWithoutTemplates(t *testing.T) {
+	tr, err := New(Templates{})
+	if err != nil || tr != nil {
+		t.Fatalf("New(Templates{}) = %v, %v, want nil, nil", tr, err)
+	}
+	event := &models.Event{Title: "Standup"}
+	if err := tr.Apply(event); err != nil || event.Title != "Standup" {
+		t.Errorf("nil Transform changed the event: %q, %v", event.Title, err)
+	}
+}
+
+func TestNewRejectsInvalidTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates Templates
+		want      string
+	}{
+		{"syntax", Templates{Title: "{{.Title"}, "invalid title template"},
+		{"unknown function", Templates{Location: "{{shout .Location}}"}, "invalid location template"},
+		{"unknown field", Templates{Description: "{{.Notes}}"}, "invalid description template"},
+		{"wrong argument", Templates{Categories: "{{upper .StartTime}}"}, "invalid categories template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.templates)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("New = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tr, err := New(Templates{
+		Title:      "[{{.CalendarID}}] {{.Title}}",
+		Location:   `{{.Location | default "Online"}}`,
+		Categories: "Work, {{.Account | upper}}, ",
+		// Sees the title before the title template ran.
+		Description: "{{.Title}}: {{.Description}}",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	event := &models.Event{Title: "Standup", Description: "Daily", Account: "work", CalendarID: "primary", StartTime: time.Now()}
+	if err := tr.Apply(event); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if event.Title != "[primary] Standup" || event.Location != "Online" || event.Description != "Standup: Daily" {
+		t.Errorf("title %q, location %q, description %q", event.Title, event.Location, event.Description)
+	}
+	if want := []string{"Work", "WORK"}; !slices.Equal(event.Categories, want) {
+		t.Errorf("categories = %q, want %q", event.Categories, want)
+	}
+}
+
+func TestApplyLeavesEventUnchangedOnError(t *testing.T) {
+	// The sample event New tries templates on has no attendees, so this one only fails on real events.
+	tr, err := New(Templates{
+		Title:    "Copy of {{.Title}}",
+		Location: "{{if .Attendees}}{{(index .Attendees 1).Email}}{{end}}",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	event := &models.Event{
+		Title:     "Standup",
+		Location:  "Room 1",
+		Attendees: []models.Attendee{{Email: "bob@example.com"}},
+	}
+	if err := tr.Apply(event); err == nil || !strings.Contains(err.Error(), "location template failed") {
+		t.Fatalf("Apply = %v, want the location template to fail", err)
+	}
+	if event.Title != "Standup" || event.Location != "Room 1" {
+		t.Errorf("event was changed to title %q, location %q", event.Title, event.Location)
+	}
+}